@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// edit is a line of an edit script: an unchanged line (' '), a line of the old
+// text that got deleted ('-') or a line of the new text that got inserted ('+').
+type edit struct {
+	op   byte
+	line string
+}
+
+// unifiedDiff returns the differences between the texts a and b in the unified
+// format, or nil if they are equal.
+func unifiedDiff(nameA, nameB string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	// lines of a and b before edits[i]
+	beforeA := make([]int, len(edits)+1)
+	beforeB := make([]int, len(edits)+1)
+	for i, e := range edits {
+		beforeA[i+1], beforeB[i+1] = beforeA[i], beforeB[i]
+		if e.op != '+' {
+			beforeA[i+1]++
+		}
+		if e.op != '-' {
+			beforeB[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+	for i := 0; i < len(edits); i++ {
+		if edits[i].op == ' ' {
+			continue
+		}
+
+		// A hunk holds the changes that are at most 2*diffContext unchanged lines
+		// apart, along with the unchanged lines around them.
+		last := i
+		for j := i + 1; j < len(edits) && j-last <= 2*diffContext+1; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := last + diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(beforeA[start]+1, beforeA[end]-beforeA[start]),
+			hunkRange(beforeB[start]+1, beforeB[end]-beforeB[start]),
+		)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&buf, "%c%s\n", e.op, e.line)
+		}
+		i = last
+	}
+	return buf.Bytes()
+}
+
+// hunkRange formats the start line and the number of lines of a hunk. An empty
+// range starts at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits text into lines without their line endings.
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+}
+
+// diffLines returns a shortest edit script turning a into b, computed with the
+// algorithm by Eugene W. Myers, "An O(ND) Difference Algorithm and Its
+// Variations".
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3) // furthest x on diagonal k, at index offset+k
+	var trace [][]int         // v for diagonals -d to d, after step d
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1] // down, an insertion
+			} else {
+				x = v[offset+k-1] + 1 // right, a deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		if v[offset+n-m] >= n && (n-m+d)%2 == 0 && -d <= n-m && n-m <= d {
+			break
+		}
+	}
+
+	// walk back from the end to the start
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && prev[k-1+d-1] < prev[k+1+d-1] {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, edit{'+', b[y-1]})
+			y--
+		} else {
+			edits = append(edits, edit{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 {
+		edits = append(edits, edit{' ', a[x-1]})
+		x--
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\no\np\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\no\np\nq\n"
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -14,3 +14,4 @@
+ n
+ o
+ p
++q
+`
+	if got := string(unifiedDiff("old", "new", []byte(a), []byte(b))); got != expected {
+		t.Errorf("expected:\n%s\n--- got:\n%s", expected, got)
+	}
+	if got := unifiedDiff("old", "new", []byte(a), []byte(a)); got != nil {
+		t.Errorf("expected no diff of equal texts, got:\n%s", got)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, r.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(3)))
+		}
+		return lines
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := randomLines(), randomLines()
+		edits := diffLines(a, b)
+		var gotA, gotB []string
+		changes := 0
+		for _, e := range edits {
+			if e.op != '+' {
+				gotA = append(gotA, e.line)
+			}
+			if e.op != '-' {
+				gotB = append(gotB, e.line)
+			}
+			if e.op != ' ' {
+				changes++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("edits %q do not turn %q into %q", edits, a, b)
+		}
+		if min := len(a) + len(b) - 2*lcsLen(a, b); changes != min {
+			t.Fatalf("edits %q turning %q into %q have %d changes, expected %d", edits, a, b, changes, min)
+		}
+	}
+}
+
+// lcsLen returns the length of the longest common subsequence of a and b.
+func lcsLen(a, b []string) int {
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				l[i][j] = l[i+1][j+1] + 1
+			case l[i+1][j] > l[i][j+1]:
+				l[i][j] = l[i+1][j]
+			default:
+				l[i][j] = l[i][j+1]
+			}
+		}
+	}
+	return l[0][0]
+}
@@ -0,0 +1,274 @@
+// Command astrewrite simplifies the Go packages named by the given patterns,
+// see the package github.com/neelance/astrewrite.
+//
+// Usage:
+//
+//	astrewrite [flags] [packages]
+//
+// The patterns are those of "go list" and default to the package in the
+// current directory. The packages get loaded with golang.org/x/tools/go/packages,
+// so modules, build tags and GOFLAGS are taken into account. Each flag of a
+// transformation enables the corresponding field of astrewrite.Options. By
+// default, the simplified files are printed to the standard output. The flags
+// -d, -o and -w select the other output modes. Declarations that can not be
+// simplified are kept as they are and reported on the standard error.
+//
+// With -line, the simplified files contain //line directives, so that errors
+// and stack traces refer to the original files. Together with -positions, the
+// generated code refers to the lines of the code that it was generated from.
+// The flag -comments keeps the comments with that code as well.
+//
+// With -overlay, the simplified files get written to a scratch directory, or
+// the directory given by -o, and the named file receives the overlay that
+// replaces the original files with them, e.g.
+//
+//	astrewrite -all -overlay overlay.json ./...
+//	go test -overlay overlay.json ./...
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/neelance/astrewrite"
+	"golang.org/x/tools/go/packages"
+)
+
+var (
+	calls         = flag.Bool("calls", false, "move nested calls into separate statements")
+	receives      = flag.Bool("receives", false, "move nested receive operations into separate statements like calls")
+	shortCircuits = flag.Bool("shortcircuits", false, "lower && and || with calls in the right operand")
+	tuples        = flag.Bool("tuples", false, "split multi-value arguments")
+	switches      = flag.Bool("switches", false, "lower switch statements")
+	typeSwitches  = flag.Bool("typeswitches", false, "lower type switch statements")
+	chanRanges    = flag.Bool("chanranges", false, "lower range loops over channels")
+	selects       = flag.Bool("select", false, "hoist the operands of select statements")
+	ranges        = flag.Bool("ranges", false, "lower other range loops")
+	all           = flag.Bool("all", false, "apply all transformations")
+	prefix        = flag.String("prefix", "", "prefix of temporary variables")
+	positions     = flag.Bool("positions", false, "give generated code the positions of the original code")
+	comments      = flag.Bool("comments", false, "keep comments with the code generated from what they belong to (implies -positions)")
+	lines         = flag.Bool("line", false, "insert //line directives referring to the original files")
+	verify        = flag.Bool("verify", false, "type-check the simplified code again and skip packages with errors")
+	tests         = flag.Bool("test", false, "also simplify the _test.go files and external test packages")
+
+	diff   = flag.Bool("d", false, "print a unified diff against the original files")
+	outDir = flag.String("o", "", "write the files to `dir`/<import path>/<file name>")
+	write  = flag.Bool("w", false, "rewrite the original files")
+
+	overlay = flag.String("overlay", "", "write the files to a scratch directory, or the one of -o, and an overlay for \"go build -overlay\" to `file`")
+)
+
+var exitCode = 0
+
+// report prints err and makes the command fail in the end.
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 1
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: astrewrite [flags] [packages]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	modes := 0
+	for _, set := range []bool{*diff, *outDir != "", *write} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		fmt.Fprintln(os.Stderr, "astrewrite: the flags -d, -o and -w can not be combined")
+		os.Exit(2)
+	}
+	if *overlay != "" && (*diff || *write) {
+		fmt.Fprintln(os.Stderr, "astrewrite: the flag -overlay can not be combined with -d or -w")
+		os.Exit(2)
+	}
+	if err := astrewrite.CheckTempPrefix(*prefix); err != nil {
+		fmt.Fprintf(os.Stderr, "astrewrite: %v\n", err)
+		os.Exit(2)
+	}
+	if *overlay != "" && *outDir == "" {
+		dir, err := os.MkdirTemp("", "astrewrite")
+		if err != nil {
+			report(fmt.Errorf("astrewrite: %v", err))
+			os.Exit(exitCode)
+		}
+		*outDir = dir
+	}
+
+	opts := &astrewrite.Options{
+		SimplifyCalls:       *calls || *all,
+		HoistReceives:       *receives || *all,
+		LowerShortCircuits:  *shortCircuits || *all,
+		SplitTuples:         *tuples || *all,
+		LowerSwitches:       *switches || *all,
+		LowerTypeSwitches:   *typeSwitches || *all,
+		LowerChanRanges:     *chanRanges || *all,
+		HoistSelectOperands: *selects || *all,
+		LowerRanges:         *ranges || *all,
+		TempPrefix:          *prefix,
+		SyntheticPositions:  *positions,
+		PreserveComments:    *comments,
+	}
+
+	pkgs, err := astrewrite.Load(&packages.Config{Tests: *tests}, flag.Args()...)
+	if err != nil {
+		report(fmt.Errorf("astrewrite: %v", err))
+		os.Exit(exitCode)
+	}
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) != 0 {
+			for _, err := range pkg.Errors {
+				report(err)
+			}
+			continue
+		}
+		rewritePackage(pkg, opts)
+	}
+	if *overlay != "" {
+		if err := writeOverlay(*overlay); err != nil {
+			report(fmt.Errorf("astrewrite: %v", err))
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// rewritePackage simplifies the files of the loaded package pkg and writes them
+// according to the output mode. Any errors get reported.
+func rewritePackage(pkg *packages.Package, opts *astrewrite.Options) {
+	if len(pkg.Syntax) == 0 {
+		return
+	}
+
+	// The syntax trees are those of the compiled files. They only match the
+	// original files if there is no cgo involved.
+	goFiles := make(map[string]bool)
+	for _, name := range pkg.GoFiles {
+		goFiles[name] = true
+	}
+	for _, name := range pkg.CompiledGoFiles {
+		if !goFiles[name] {
+			report(fmt.Errorf("astrewrite: %s: packages using cgo are not supported", pkg.ID))
+			return
+		}
+	}
+
+	failed := false
+	imp := astrewrite.PackageImporter(pkg)
+	pkgOpts := *opts
+	pkgOpts.Importer = imp
+	pkgOpts.Fset = pkg.Fset
+	if *verify {
+		pkgOpts.Verify = &astrewrite.VerifyOptions{
+			Fset:     pkg.Fset,
+			Importer: imp,
+			Error: func(err error) {
+				report(err)
+				failed = true
+			},
+		}
+	}
+	simplifiedFiles, diagnostics, err := astrewrite.SimplifyPackageDiagnostics(pkg.Syntax, pkg.Types, pkg.TypesInfo, &pkgOpts)
+	if err != nil {
+		report(fmt.Errorf("astrewrite: %s: %v", pkg.ID, err))
+		return
+	}
+	for _, d := range diagnostics {
+		// the declarations concerned stay as they are, which is not an error
+		fmt.Fprintf(os.Stderr, "%s: %s\n", pkg.Fset.Position(d.Pos), d.Message)
+	}
+	if failed {
+		// do not write invalid code
+		return
+	}
+
+	// the files of an external test package live next to the package under test
+	dirPath := pkg.PkgPath
+	if pkg.ForTest != "" {
+		dirPath = pkg.ForTest
+	}
+	for i, file := range simplifiedFiles {
+		var buf bytes.Buffer
+		if err := astrewrite.Fprint(&buf, pkg.Fset, file, *lines); err != nil {
+			report(err)
+			continue
+		}
+		filename := pkg.Fset.Position(pkg.Syntax[i].Package).Filename
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			report(err)
+			continue
+		}
+		if err := output(filename, dirPath, src, buf.Bytes()); err != nil {
+			report(err)
+		}
+	}
+}
+
+// output writes the simplified source of the file filename, which belongs to
+// the package with the given import path, according to the output mode.
+func output(filename, importPath string, orig, simplified []byte) error {
+	switch {
+	case *diff:
+		_, err := os.Stdout.Write(unifiedDiff(filename+".orig", filename, orig, simplified))
+		return err
+	case *outDir != "":
+		path := filepath.Join(*outDir, filepath.FromSlash(importPath), filepath.Base(filename))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, simplified, 0666); err != nil {
+			return err
+		}
+		if *overlay != "" {
+			// relative paths in an overlay are relative to the directory of the go
+			// command, not to the one of this command
+			from, err := filepath.Abs(filename)
+			if err != nil {
+				return err
+			}
+			to, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			overlayReplace[from] = to
+		}
+		return nil
+	case *write:
+		if bytes.Equal(orig, simplified) {
+			return nil
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, simplified, info.Mode().Perm())
+	default:
+		_, err := os.Stdout.Write(simplified)
+		return err
+	}
+}
+
+// overlayReplace maps the original files to the simplified ones written so far.
+var overlayReplace = make(map[string]string)
+
+// writeOverlay writes the file given to "go build -overlay" that replaces the
+// original files with the simplified ones.
+func writeOverlay(filename string) error {
+	data, err := json.MarshalIndent(struct{ Replace map[string]string }{overlayReplace}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0666)
+}
@@ -1,27 +1,19 @@
 #!/bin/bash
+# Usage: all.sh [astrewrite flags], e.g. all.sh -switches -chanranges
+#
+# Simplifies the standard library into the directory out and builds and tests
+# the result through the overlay in overlay.json, leaving GOROOT untouched.
 set -e
 
 export CGO_ENABLED=0
 
-rm -rf goroot
-mkdir goroot
-ln -s $(go env GOROOT)/test goroot/test
-ln -s $(go env GOROOT)/lib goroot/lib
+rm -rf out overlay.json
 
-cp -r $(go env GOROOT)/src goroot/src
+PACKAGES=$(go list std | egrep -v "runtime|builtin|cmd|sync")
 
-mkdir goroot/pkg
-ln -s $(go env GOROOT)/pkg/tool goroot/pkg/tool
-ln -s $(go env GOROOT)/pkg/include goroot/pkg/include
+go build -o astrewrite ../cmd/astrewrite
+./astrewrite -o out -overlay overlay.json "$@" $PACKAGES
 
-PACKAGES=$(cd $(go env GOROOT)/src; go list ./... | egrep -v "runtime|builtin|cmd|sync")
+go build -overlay overlay.json $PACKAGES
 
-go build rewrite_package.go
-for pkg in $PACKAGES; do
-	echo $pkg
-	./rewrite_package $pkg
-done
-
-env GOROOT=$PWD/goroot $(which go) install -v $PACKAGES
-
-env GOROOT=$PWD/goroot $(which go) test -short $PACKAGES
+go test -short -overlay overlay.json $PACKAGES
@@ -0,0 +1,253 @@
+package astrewrite
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"maps"
+)
+
+// A Diagnostic reports a part of the input that SimplifyPackageDiagnostics left
+// unchanged because it could not be simplified.
+type Diagnostic struct {
+	Pos     token.Pos
+	Message string
+}
+
+// SimplifyPackageDiagnostics is like SimplifyPackage, but does not panic on
+// input that it can not handle. A top-level declaration that fails to get
+// simplified is kept as it is and reported by a diagnostic at the position of
+// the innermost statement concerned, so the other declarations still get
+// simplified. The error is non-nil if the package can not be simplified at
+// all, e.g. because info lacks some of the required maps, in which case there
+// are no files. It updates info and, as it does not return a copy of info,
+// returns an error if opts.CopyInfo is set.
+func SimplifyPackageDiagnostics(files []*ast.File, pkg *types.Package, info *types.Info, opts *Options) (newFiles []*ast.File, diagnostics []Diagnostic, err error) {
+	if info == nil || info.Types == nil || info.Defs == nil || info.Uses == nil || info.Scopes == nil {
+		return nil, nil, errors.New("the maps Types, Defs, Uses and Scopes of types.Info are required")
+	}
+	if opts != nil {
+		if err := CheckTempPrefix(opts.TempPrefix); err != nil {
+			return nil, nil, err
+		}
+		if opts.CopyInfo {
+			return nil, nil, errors.New("CopyInfo requires SimplifyPackage")
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			newFiles, diagnostics, err = nil, nil, fmt.Errorf("internal error: %v", unwrapPanic(r))
+		}
+	}()
+
+	c := newSimplifyContext(pkg, info, opts)
+	c.diagnostics = &diagnostics
+	newFiles = make([]*ast.File, len(files))
+	for i, file := range files {
+		newFiles[i] = c.simplifyFile(file)
+	}
+	c.verify(newFiles)
+	return newFiles, diagnostics, nil
+}
+
+// simplifyDeclOrReport is like simplifyDecl, but returns decl itself and adds a
+// diagnostic if simplifying it panics. The imports needed by the discarded
+// result are dropped again, as are the right-hand sides of its initializers,
+// the objects and scopes that it declares and the entries that it adds to info
+// and Options.Provenance, see pendingScopes.
+func (c *simplifyContext) simplifyDeclOrReport(decl ast.Decl) (newDecl ast.Decl) {
+	scope, origin := c.scope, c.origin
+	fileImports, newImports := maps.Clone(c.fileImports), len(c.newImports)
+	var pkgImports []*types.Package
+	if c.pkg != nil {
+		pkgImports = c.pkg.Imports()
+	}
+	rhs := make(map[*types.Initializer]ast.Expr)
+	if decl, ok := decl.(*ast.GenDecl); ok {
+		for _, spec := range decl.Specs {
+			if spec, ok := spec.(*ast.ValueSpec); ok {
+				for _, v := range spec.Values {
+					if initializer, ok := c.initializers[v]; ok {
+						rhs[initializer] = initializer.Rhs
+					}
+				}
+			}
+		}
+	}
+	c.pending = &pendingScopes{
+		objects: make(map[*types.Scope][]types.Object),
+		parents: make(map[*types.Scope]*types.Scope),
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			c.commitScopes()
+			return
+		}
+		pos := decl.Pos()
+		if p, ok := r.(*locatedPanic); ok {
+			pos = p.pos
+		}
+		*c.diagnostics = append(*c.diagnostics, Diagnostic{
+			Pos:     pos,
+			Message: fmt.Sprintf("cannot simplify: %v", unwrapPanic(r)),
+		})
+		c.scope, c.origin = scope, origin
+		c.fileImports, c.newImports = fileImports, c.newImports[:newImports]
+		if c.pkg != nil {
+			c.pkg.SetImports(pkgImports)
+		}
+		for initializer, x := range rhs {
+			initializer.Rhs = x
+		}
+		for i := len(c.pending.undo) - 1; i >= 0; i-- {
+			c.pending.undo[i]()
+		}
+		c.pending = nil
+		newDecl = decl
+	}()
+	return c.simplifyDecl(decl)
+}
+
+// pendingScopes holds the changes to the scopes made while simplifying a
+// declaration with simplifyDeclOrReport. Since a types.Scope can not drop
+// objects or children again, they only get applied by commitScopes once the
+// declaration has been simplified. The changes to the maps of info and to the
+// provenance get applied right away, but can be undone, see record.
+type pendingScopes struct {
+	objects map[*types.Scope][]types.Object // objects to insert into each scope
+	parents map[*types.Scope]*types.Scope   // parents of the new scopes
+	scopes  []pendingScope                  // new scopes in order of creation
+	undo    []func()                        // reverts the entries set by record
+}
+
+type pendingScope struct {
+	node    ast.Node
+	scope   *types.Scope // stand-in without parent
+	comment string
+}
+
+// record sets m[k] to v, e.g. for a map of info. If the scopes are pending,
+// discarding the declaration restores the previous entry.
+func record[K comparable, V any](c *simplifyContext, m map[K]V, k K, v V) {
+	if c.pending != nil {
+		if old, ok := m[k]; ok {
+			c.pending.undo = append(c.pending.undo, func() { m[k] = old })
+		} else {
+			c.pending.undo = append(c.pending.undo, func() { delete(m, k) })
+		}
+	}
+	m[k] = v
+}
+
+// The following methods set an entry of the respective map of info, see record.
+
+func (c *simplifyContext) recordType(x ast.Expr, tv types.TypeAndValue) {
+	record(c, c.info.Types, x, tv)
+}
+
+func (c *simplifyContext) recordDef(id *ast.Ident, obj types.Object) {
+	record(c, c.info.Defs, id, obj)
+}
+
+func (c *simplifyContext) recordUse(id *ast.Ident, obj types.Object) {
+	record(c, c.info.Uses, id, obj)
+}
+
+func (c *simplifyContext) recordImplicit(node ast.Node, obj types.Object) {
+	record(c, c.info.Implicits, node, obj)
+}
+
+func (c *simplifyContext) recordSelection(x *ast.SelectorExpr, sel *types.Selection) {
+	record(c, c.info.Selections, x, sel)
+}
+
+func (c *simplifyContext) recordScope(node ast.Node, scope *types.Scope) {
+	record(c, c.info.Scopes, node, scope)
+}
+
+// insert inserts obj into scope, or records it if the scopes are pending.
+func (c *simplifyContext) insert(scope *types.Scope, obj types.Object) {
+	if c.pending == nil {
+		scope.Insert(obj)
+		return
+	}
+	c.pending.objects[scope] = append(c.pending.objects[scope], obj)
+}
+
+// newScope creates a child scope of parent for node and records it in
+// info.Scopes. If the scopes are pending, it is a stand-in that is not attached
+// to parent yet.
+func (c *simplifyContext) newScope(node ast.Node, parent *types.Scope, pos, end token.Pos, comment string) *types.Scope {
+	if c.pending == nil {
+		c.info.Scopes[node] = types.NewScope(parent, pos, end, comment)
+		return c.info.Scopes[node]
+	}
+	scope := types.NewScope(nil, pos, end, comment)
+	c.pending.parents[scope] = parent
+	c.pending.scopes = append(c.pending.scopes, pendingScope{node, scope, comment})
+	c.recordScope(node, scope)
+	return scope
+}
+
+// parentScope returns the parent of scope, including pending ones.
+func (c *simplifyContext) parentScope(scope *types.Scope) *types.Scope {
+	if c.pending != nil {
+		if parent, ok := c.pending.parents[scope]; ok {
+			return parent
+		}
+	}
+	return scope.Parent()
+}
+
+// commitScopes applies the pending changes to the scopes.
+func (c *simplifyContext) commitScopes() {
+	p := c.pending
+	c.pending = nil
+	replaced := make(map[*types.Scope]*types.Scope)
+	for _, s := range p.scopes {
+		parent := p.parents[s.scope]
+		if newParent, ok := replaced[parent]; ok {
+			parent = newParent
+		}
+		replaced[s.scope] = types.NewScope(parent, s.scope.Pos(), s.scope.End(), s.comment)
+		c.info.Scopes[s.node] = replaced[s.scope]
+	}
+	for scope, objects := range p.objects {
+		if newScope, ok := replaced[scope]; ok {
+			scope = newScope
+		}
+		for _, obj := range objects {
+			scope.Insert(obj)
+		}
+	}
+}
+
+// A locatedPanic carries a panic that occurred while simplifying the statement
+// at pos.
+type locatedPanic struct {
+	pos   token.Pos
+	value any
+}
+
+// locatePanic attaches pos to a panic in progress that has no position yet. It
+// must be deferred.
+func locatePanic(pos token.Pos) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(*locatedPanic); !ok && pos.IsValid() {
+		r = &locatedPanic{pos: pos, value: r}
+	}
+	panic(r)
+}
+
+func unwrapPanic(r any) any {
+	if p, ok := r.(*locatedPanic); ok {
+		return p.value
+	}
+	return r
+}
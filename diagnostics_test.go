@@ -0,0 +1,224 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func TestSimplifyPackageDiagnostics(t *testing.T) {
+	src := `package main
+
+func f(c chan int) {
+	g(h())
+	select {
+	case <-c:
+	}
+}
+
+func main() {
+	g(h())
+}
+
+func g(int)   {}
+func h() int { return 0 }
+`
+	fset := token.NewFileSet()
+	file := parse(t, fset, src)
+	pkg, typesInfo := typeCheck(t, fset, file)
+	// a receive operation that the type checker would have rejected
+	f := file.Decls[0].(*ast.FuncDecl)
+	selectStmt := f.Body.List[1].(*ast.SelectStmt)
+	comm := selectStmt.Body.List[0].(*ast.CommClause).Comm.(*ast.ExprStmt)
+	comm.X.(*ast.UnaryExpr).Op = token.NOT
+
+	outFiles, diagnostics, err := SimplifyPackageDiagnostics([]*ast.File{file}, pkg, typesInfo, allLowerings(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagnostics)
+	}
+	if pos := fset.Position(diagnostics[0].Pos); pos.Line != 5 || diagnostics[0].Message != "cannot simplify: unexpected comm clause" {
+		t.Errorf("unexpected diagnostic at %s: %s", pos, diagnostics[0].Message)
+	}
+	if outFiles[0].Decls[0] != f {
+		t.Errorf("expected the function to be kept")
+	}
+	expected := `package main
+
+func f(c chan int) {
+	g(h())
+	select {
+	case !c:
+	}
+}
+
+func main() {
+	_1 := h()
+	g(_1)
+}
+
+func g(int)   {}
+func h() int { return 0 }
+`
+	if got := fprint(t, fset, outFiles[0]); got != fprint(t, fset, parse(t, fset, expected)) {
+		t.Errorf("expected:\n%s\n--- got:\n%s", expected, got)
+	}
+
+	if _, _, err := SimplifyPackageDiagnostics([]*ast.File{file}, pkg, &types.Info{}, nil); err == nil {
+		t.Error("expected an error for missing type information")
+	}
+	if _, _, err := SimplifyPackageDiagnostics([]*ast.File{file}, pkg, typesInfo, &Options{SimplifyCalls: true, TempPrefix: "tmp-"}); err == nil {
+		t.Error("expected an error for an invalid prefix")
+	}
+	if _, _, err := SimplifyPackageDiagnostics([]*ast.File{file}, pkg, typesInfo, &Options{SimplifyCalls: true, CopyInfo: true}); err == nil {
+		t.Error("expected an error for CopyInfo")
+	}
+}
+
+func TestSimplifyPackageDiagnosticsRollback(t *testing.T) {
+	src := `package main
+
+import "os"
+
+var c chan int
+
+var (
+	x = g(h())
+	y = func() int {
+		for range os.Args[0] {
+			g(h())
+		}
+		select {
+		case <-c:
+		}
+		return 0
+	}()
+)
+
+func main() {
+	g(h())
+}
+
+func g(int) int { return 0 }
+func h() int    { return 0 }
+`
+	fset := token.NewFileSet()
+	file := parse(t, fset, src)
+	pkg, typesInfo := typeCheck(t, fset, file)
+	// a receive operation that the type checker would have rejected
+	lit := file.Decls[2].(*ast.GenDecl).Specs[1].(*ast.ValueSpec).Values[0].(*ast.CallExpr).Fun.(*ast.FuncLit)
+	comm := lit.Body.List[1].(*ast.SelectStmt).Body.List[0].(*ast.CommClause).Comm.(*ast.ExprStmt)
+	comm.X.(*ast.UnaryExpr).Op = token.NOT
+
+	var rhs []ast.Expr
+	for _, initializer := range typesInfo.InitOrder {
+		rhs = append(rhs, initializer.Rhs)
+	}
+	fileScope := typesInfo.Scopes[file]
+	children := fileScope.NumChildren()
+	imports := len(pkg.Imports())
+
+	provenance := make(Provenance)
+	outFiles, diagnostics, err := SimplifyPackageDiagnostics([]*ast.File{file}, pkg, typesInfo, allLowerings(Options{LowerRanges: true, Provenance: provenance}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagnostics)
+	}
+	for i, initializer := range typesInfo.InitOrder {
+		if initializer.Rhs != rhs[i] {
+			t.Errorf("expected the initializer of %s to be kept", initializer.Lhs[0].Name())
+		}
+	}
+	if got := fileScope.NumChildren(); got != children {
+		t.Errorf("expected %d scopes in the file scope, got %d", children, got)
+	}
+	if fileScope.Lookup("utf8") != nil {
+		t.Error("expected the import of the discarded declaration to be dropped")
+	}
+	if got := len(pkg.Imports()); got != imports {
+		t.Errorf("expected %d imports of the package, got %v", imports, pkg.Imports())
+	}
+	for node, scope := range typesInfo.Scopes {
+		if node == file.Decls[3].(*ast.FuncDecl).Type {
+			if scope.Len() != 1 {
+				t.Errorf("expected the temporary variable of main, got %v", scope.Names())
+			}
+			continue
+		}
+		if scope != nil && scope.Len() != 0 && strings.HasPrefix(scope.Names()[0], "_") {
+			t.Errorf("expected no temporary variables, got %v in %s", scope.Names(), fset.Position(scope.Pos()))
+		}
+	}
+
+	// the entries for the nodes of the discarded result are dropped again
+	nodes := make(map[ast.Node]bool)
+	for _, f := range []*ast.File{file, outFiles[0]} {
+		ast.Inspect(f, func(n ast.Node) bool {
+			nodes[n] = true
+			return true
+		})
+	}
+	var keys []ast.Node
+	for x := range typesInfo.Types {
+		keys = append(keys, x)
+	}
+	for id := range typesInfo.Defs {
+		keys = append(keys, id)
+	}
+	for id := range typesInfo.Uses {
+		keys = append(keys, id)
+	}
+	for node := range typesInfo.Implicits {
+		keys = append(keys, node)
+	}
+	for x := range typesInfo.Selections {
+		keys = append(keys, x)
+	}
+	for node := range typesInfo.Scopes {
+		keys = append(keys, node)
+	}
+	for node := range provenance {
+		keys = append(keys, node)
+	}
+	for _, key := range keys {
+		if !nodes[key] {
+			t.Errorf("unexpected entry for %T at %s", key, fset.Position(key.Pos()))
+		}
+	}
+}
+
+func TestSimplifyPackageDiagnosticsInitializer(t *testing.T) {
+	src := `package main
+
+import "encoding/binary"
+
+var x = id(id(binary.LittleEndian))
+var y = id(id(binary.BigEndian.Uint16(nil)))
+
+func id[T any](v T) T { return v }
+`
+	fset := token.NewFileSet()
+	file := parse(t, fset, src)
+	pkg, typesInfo := typeCheck(t, fset, file)
+
+	outFiles, diagnostics, err := SimplifyPackageDiagnostics([]*ast.File{file}, pkg, typesInfo, &Options{SimplifyCalls: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagnostics)
+	}
+	if pos := fset.Position(diagnostics[0].Pos); pos.Line != 5 || diagnostics[0].Message != "cannot simplify: the type encoding/binary.littleEndian of the value can not be denoted" {
+		t.Errorf("unexpected diagnostic at %s: %s", pos, diagnostics[0].Message)
+	}
+	got := fprint(t, fset, outFiles[0])
+	if !strings.Contains(got, "var x = id(id(binary.LittleEndian))") || !strings.Contains(got, "var y = func() uint16") {
+		t.Errorf("expected only the value of y to be simplified, got:\n%s", got)
+	}
+}
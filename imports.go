@@ -0,0 +1,231 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"strconv"
+	"sync"
+)
+
+// lookupPackage returns the package with the given import path. It is searched
+// among the packages imported by the simplified package, directly or
+// indirectly, before it is loaded with the importer. It returns nil if the
+// package can not be found, or if it is the simplified package or imports it,
+// since the import would create a cycle.
+func (c *simplifyContext) lookupPackage(path string) *types.Package {
+	if pkg, ok := c.packages[path]; ok {
+		return pkg
+	}
+	var pkg *types.Package
+	if c.pkg != nil {
+		pkg = findImport(c.pkg, path, make(map[*types.Package]bool))
+	}
+	if pkg == nil {
+		imp := c.importer
+		if imp == nil {
+			imp = importer.Default()
+		}
+		pkg, _ = imp.Import(path)
+	}
+	if pkg != nil && c.pkg != nil && (pkg.Path() == c.pkg.Path() || findImport(pkg, c.pkg.Path(), make(map[*types.Package]bool)) != nil || dependsOn(pkg.Path(), "", c.pkg.Path(), make(map[string]bool))) {
+		pkg = nil
+	}
+	c.packages[path] = pkg
+	return pkg
+}
+
+func findImport(pkg *types.Package, path string, seen map[*types.Package]bool) *types.Package {
+	for _, imp := range pkg.Imports() {
+		if imp.Path() == path {
+			return imp
+		}
+		if seen[imp] {
+			continue
+		}
+		seen[imp] = true
+		if found := findImport(imp, path, seen); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// dependsOn reports whether the package with the given import path, as seen
+// from the directory srcDir, imports the package target, directly or
+// indirectly. The imports are read from the source files with go/build, since
+// a package loaded from export data only lists the imports that its exported
+// declarations refer to. Packages that can not be found count as not importing
+// target.
+func dependsOn(path, srcDir, target string, seen map[string]bool) bool {
+	bp, err := buildImport(path, srcDir)
+	if err != nil {
+		return false
+	}
+	if bp.ImportPath == target {
+		return true
+	}
+	if seen[bp.ImportPath] {
+		return false
+	}
+	seen[bp.ImportPath] = true
+	for _, imp := range bp.Imports {
+		if dependsOn(imp, bp.Dir, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+var buildCache sync.Map // [2]string{path, srcDir} → *build.Package or error
+
+// buildImport returns the package found by go/build for the given import path
+// and source directory. The results are cached, since the dependencies of the
+// same packages get looked up for every simplified package.
+func buildImport(path, srcDir string) (*build.Package, error) {
+	key := [2]string{path, srcDir}
+	if v, ok := buildCache.Load(key); ok {
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v.(*build.Package), nil
+	}
+	bp, err := build.Import(path, srcDir, 0)
+	if err != nil {
+		buildCache.Store(key, err)
+		return nil, err
+	}
+	buildCache.Store(key, bp)
+	return bp, nil
+}
+
+// qualifiedIdent returns the qualified identifier pkg.name. The package gets
+// imported into the current file if it is not already.
+func (c *simplifyContext) qualifiedIdent(pkg *types.Package, name string) ast.Expr {
+	x := ast.NewIdent(c.importName(pkg))
+	c.recordUse(x, c.fileImports[pkg.Path()])
+	sel := ast.NewIdent(name)
+	obj := pkg.Scope().Lookup(name)
+	c.recordUse(sel, obj)
+	return c.setType(&ast.SelectorExpr{X: x, Sel: sel}, obj.Type())
+}
+
+// importName returns the name under which pkg is imported into the current
+// file, adding an import declaration if necessary. A new import gets renamed if
+// the package name is already taken in the file.
+func (c *simplifyContext) importName(pkg *types.Package) string {
+	if pkgName, ok := c.fileImports[pkg.Path()]; ok && (c.scope == nil || c.lookupParent(c.scope, pkgName.Name()) == pkgName) {
+		return pkgName.Name()
+	}
+
+	name := pkg.Name()
+	for i := 1; c.scope != nil && (c.lookupParent(c.scope, name) != nil || isDeclaredInChildren(c.fileScope, name)); i++ {
+		name = c.tempPrefix + pkg.Name() + strconv.Itoa(i)
+	}
+
+	spec := &ast.ImportSpec{
+		Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(pkg.Path())},
+	}
+	pkgName := types.NewPkgName(token.NoPos, c.pkg, name, pkg)
+	if name != pkg.Name() {
+		spec.Name = ast.NewIdent(name)
+		c.recordDef(spec.Name, pkgName)
+	} else if c.info.Implicits != nil {
+		c.recordImplicit(spec, pkgName)
+	}
+	if c.fileScope != nil {
+		c.insert(c.fileScope, pkgName)
+	}
+	if c.pkg != nil && !c.copyInfo && findImport(c.pkg, pkg.Path(), map[*types.Package]bool{c.pkg: true}) != pkg {
+		c.pkg.SetImports(append(c.pkg.Imports(), pkg))
+	}
+	c.fileImports[pkg.Path()] = pkgName
+	c.newImports = append(c.newImports, spec)
+	c.setOrigin(c.origin, spec)
+	return name
+}
+
+// collectImports records the packages imported by file.
+func (c *simplifyContext) collectImports(file *ast.File) {
+	c.fileImports = make(map[string]*types.PkgName)
+	c.newImports = nil
+	for _, spec := range file.Imports {
+		obj := c.info.Implicits[spec]
+		if spec.Name != nil {
+			obj = c.info.Defs[spec.Name]
+		}
+		if pkgName, ok := obj.(*types.PkgName); ok && pkgName.Name() != "_" && pkgName.Name() != "." {
+			c.fileImports[pkgName.Imported().Path()] = pkgName
+		}
+	}
+}
+
+// addImports adds the imports needed by the generated code to the last import
+// declaration in decls, or to a new one if there is none. It returns the new
+// declarations and the imports of the file.
+func (c *simplifyContext) addImports(file *ast.File, decls []ast.Decl) ([]ast.Decl, []*ast.ImportSpec) {
+	if len(c.newImports) == 0 {
+		return decls, file.Imports
+	}
+	newDecls := append([]ast.Decl{}, decls...)
+	decl := &ast.GenDecl{Tok: token.IMPORT}
+	i := 0
+	for i < len(decls) {
+		d, ok := decls[i].(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			break
+		}
+		decl = d
+		i++
+	}
+	specs := append([]ast.Spec{}, decl.Specs...)
+	for _, spec := range c.newImports {
+		// keep sorted imports sorted
+		j := len(specs)
+		for k, other := range specs {
+			if other.(*ast.ImportSpec).Path.Value > spec.Path.Value {
+				j = k
+				break
+			}
+		}
+		specs = append(specs[:j], append([]ast.Spec{spec}, specs[j:]...)...)
+	}
+	newDecl := &ast.GenDecl{
+		Doc:    decl.Doc,
+		TokPos: decl.TokPos,
+		Tok:    token.IMPORT,
+		Lparen: decl.Lparen,
+		Specs:  specs,
+		Rparen: decl.Rparen,
+	}
+	if i == 0 {
+		newDecls = append([]ast.Decl{newDecl}, newDecls...)
+	} else {
+		newDecls[i-1] = newDecl
+		c.setOrigin(decl, newDecl)
+	}
+	return newDecls, append(append([]*ast.ImportSpec{}, file.Imports...), c.newImports...)
+}
+
+// lookupParent is like scope.LookupParent, but ignores the positions of the
+// declarations and takes pending objects and scopes into account, see
+// pendingScopes.
+func (c *simplifyContext) lookupParent(scope *types.Scope, name string) types.Object {
+	if c.pending == nil {
+		_, obj := scope.LookupParent(name, token.NoPos)
+		return obj
+	}
+	for ; scope != nil; scope = c.parentScope(scope) {
+		if obj := scope.Lookup(name); obj != nil {
+			return obj
+		}
+		for _, obj := range c.pending.objects[scope] {
+			if obj.Name() == name {
+				return obj
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package astrewrite
+
+import (
+	"go/types"
+	"maps"
+)
+
+// copyInfo returns a copy of info that can be updated without affecting info,
+// see Options.CopyInfo. The objects and types are shared. The scopes of the
+// files and everything within them are copied, since new declarations get
+// inserted into them. Their parent, the package scope, gets copied as well,
+// since the copies would otherwise get registered as its children.
+func copyInfo(info *types.Info) *types.Info {
+	newInfo := &types.Info{
+		Types:        maps.Clone(info.Types),
+		Instances:    maps.Clone(info.Instances),
+		Defs:         maps.Clone(info.Defs),
+		Uses:         maps.Clone(info.Uses),
+		Implicits:    maps.Clone(info.Implicits),
+		Selections:   maps.Clone(info.Selections),
+		Scopes:       maps.Clone(info.Scopes),
+		FileVersions: maps.Clone(info.FileVersions),
+	}
+	for _, initializer := range info.InitOrder {
+		newInfo.InitOrder = append(newInfo.InitOrder, &types.Initializer{
+			Lhs: append([]*types.Var(nil), initializer.Lhs...),
+			Rhs: initializer.Rhs,
+		})
+	}
+
+	// Copy the scope trees starting at the scopes whose parents are not
+	// described by info, i.e. those of the files.
+	described := make(map[*types.Scope]bool)
+	for _, scope := range info.Scopes {
+		described[scope] = true
+	}
+	copies := make(map[*types.Scope]*types.Scope)
+	for _, scope := range info.Scopes {
+		if parent := scope.Parent(); !described[parent] {
+			if parent != nil && parent != types.Universe {
+				if _, ok := copies[parent]; !ok {
+					// the universe does not record its children
+					copies[parent] = types.NewScope(types.Universe, parent.Pos(), parent.End(), "")
+					for _, name := range parent.Names() {
+						copies[parent].Insert(parent.Lookup(name))
+					}
+				}
+				parent = copies[parent]
+			}
+			copyScope(scope, parent, copies)
+		}
+	}
+	for node, scope := range newInfo.Scopes {
+		if newScope, ok := copies[scope]; ok {
+			newInfo.Scopes[node] = newScope
+		}
+	}
+	return newInfo
+}
+
+// copyScope copies scope and its children, making the copy a child of parent.
+// It records all copies in copies.
+func copyScope(scope, parent *types.Scope, copies map[*types.Scope]*types.Scope) {
+	if _, ok := copies[scope]; ok {
+		return
+	}
+	newScope := types.NewScope(parent, scope.Pos(), scope.End(), "")
+	for _, name := range scope.Names() {
+		newScope.Insert(scope.Lookup(name))
+	}
+	copies[scope] = newScope
+	for i := 0; i < scope.NumChildren(); i++ {
+		copyScope(scope.Child(i), newScope, copies)
+	}
+}
@@ -0,0 +1,91 @@
+package astrewrite
+
+import (
+	"go/importer"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// LoadMode is the part of packages.Config.Mode that Load always requests. It
+// provides the syntax and the full type information that Simplify needs.
+const LoadMode = packages.NeedName | packages.NeedForTest | packages.NeedFiles |
+	packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedTypes |
+	packages.NeedSyntax | packages.NeedTypesInfo
+
+// Load loads, parses and type-checks the packages matching patterns with
+// golang.org/x/tools/go/packages, which follows modules, build tags and
+// GOFLAGS like the go command does. The configuration cfg may be nil; LoadMode
+// gets added to its mode.
+//
+// If cfg.Tests is set, a package with tests is returned in its variant that
+// includes the _test.go files instead of the plain one, followed by its external
+// test package, if any. The generated test main packages are left out. This way
+// each file appears in exactly one of the returned packages.
+//
+// Packages with errors are returned as well, see packages.Package.Errors. The
+// returned error is only about the loading as a whole.
+func Load(cfg *packages.Config, patterns ...string) ([]*packages.Package, error) {
+	var c packages.Config
+	if cfg != nil {
+		c = *cfg
+	}
+	c.Mode |= LoadMode
+	pkgs, err := packages.Load(&c, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Tests {
+		return pkgs, nil
+	}
+
+	tested := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if pkg.ForTest == pkg.PkgPath {
+			tested[pkg.PkgPath] = true
+		}
+	}
+	var result []*packages.Package
+	for _, pkg := range pkgs {
+		switch {
+		case pkg.ForTest == "" && tested[pkg.PkgPath]:
+			// replaced by the variant with the test files
+		case pkg.ForTest == "" && pkg.Name == "main" && strings.HasSuffix(pkg.PkgPath, ".test"):
+			// generated test main package
+		default:
+			result = append(result, pkg)
+		}
+	}
+	return result, nil
+}
+
+// PackageImporter returns an importer that resolves import paths to the
+// packages imported by pkg, directly or indirectly, so that Simplify and Verify
+// see the same packages that pkg was type-checked against. Other packages are
+// loaded with importer.Default().
+func PackageImporter(pkg *packages.Package) types.Importer {
+	return &packageImporter{pkg: pkg}
+}
+
+type packageImporter struct {
+	pkg      *packages.Package
+	fallback types.Importer
+}
+
+func (imp *packageImporter) Import(path string) (*types.Package, error) {
+	// direct imports are keyed by the path as written in the source, which may
+	// differ from the path of the package because of vendoring
+	if dep, ok := imp.pkg.Imports[path]; ok && dep.Types != nil {
+		return dep.Types, nil
+	}
+	if imp.pkg.Types != nil {
+		if dep := findImport(imp.pkg.Types, path, make(map[*types.Package]bool)); dep != nil {
+			return dep, nil
+		}
+	}
+	if imp.fallback == nil {
+		imp.fallback = importer.Default()
+	}
+	return imp.fallback.Import(path)
+}
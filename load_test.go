@@ -0,0 +1,104 @@
+package astrewrite
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"p/p.go": `package p
+
+func F(a, b int) int { return g(a) + g(b) }
+
+func g(x int) int { return x * 2 }
+`,
+		"p/p_test.go": `package p
+
+import "testing"
+
+func TestF(t *testing.T) {
+	if F(g(1), g(2)) != 12 {
+		t.Fail()
+	}
+}
+`,
+		"p/x_test.go": `package p_test
+
+import (
+	"strings"
+	"testing"
+
+	"example.com/m/p"
+)
+
+func TestX(t *testing.T) {
+	if p.F(len(strings.Repeat("a", 1)), 2) != 6 {
+		t.Fail()
+	}
+}
+`,
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &packages.Config{
+		Dir:   dir,
+		Env:   append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod"),
+		Tests: true,
+	}
+	pkgs, err := Load(cfg, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids, names []string
+	for _, pkg := range pkgs {
+		ids = append(ids, pkg.ID)
+		for _, err := range pkg.Errors {
+			t.Errorf("%s: %s", pkg.ID, err)
+		}
+		for _, file := range pkg.Syntax {
+			names = append(names, filepath.Base(pkg.Fset.Position(file.Package).Filename))
+		}
+	}
+	if got, expected := strings.Join(ids, " "), "example.com/m/p [example.com/m/p.test] example.com/m/p_test [example.com/m/p.test]"; got != expected {
+		t.Errorf("expected packages %q, got %q", expected, got)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "p.go p_test.go x_test.go" {
+		t.Errorf("expected each file once, got %s", got)
+	}
+	if t.Failed() {
+		return
+	}
+
+	for _, pkg := range pkgs {
+		imp := PackageImporter(pkg)
+		SimplifyPackage(pkg.Syntax, pkg.Types, pkg.TypesInfo, &Options{
+			SimplifyCalls: true,
+			Importer:      imp,
+			Verify: &VerifyOptions{
+				Fset:     pkg.Fset,
+				Importer: imp,
+				Error: func(err error) {
+					t.Errorf("%s: %s", pkg.ID, err)
+				},
+			},
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package astrewrite
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// A Pass rewrites a file of a type-checked package.
+type Pass interface {
+	// Name returns a short name of the pass, used in error messages.
+	Name() string
+
+	// Run returns a rewritten copy of file along with type information that
+	// covers the new nodes. The returned info may be info itself, updated in
+	// place.
+	Run(file *ast.File, info *types.Info) (*ast.File, *types.Info)
+}
+
+type optionsPass struct {
+	name string
+	opts Options
+}
+
+// NewPass returns a pass with the given name that applies the transformations
+// selected by opts, like Simplify does. With opts.CopyInfo, the pass returns a
+// copy of the given info. The field Verify of opts is ignored, see
+// Pipeline.Verify instead.
+func NewPass(name string, opts *Options) Pass {
+	p := &optionsPass{name: name}
+	if opts != nil {
+		p.opts = *opts
+	}
+	p.opts.Verify = nil
+	return p
+}
+
+func (p *optionsPass) Name() string {
+	return p.name
+}
+
+func (p *optionsPass) Run(file *ast.File, info *types.Info) (*ast.File, *types.Info) {
+	c := newSimplifyContext(nil, info, &p.opts)
+	return c.simplifyFile(file), c.info
+}
+
+// The built-in passes, each applying one of the transformations of Options.
+// They only wrap flags of Options and can not be reordered independently: a
+// pass may produce code that only the passes following it in DefaultPasses
+// simplify, e.g. CallsPass moves the nested calls that RangesPass generates
+// for range loops over maps into separate statements. Running them in another
+// order leaves such code as it is. Setting their flags in one Options, e.g.
+// for NewPass, applies them all at once instead.
+var (
+	// RangesPass lowers range loops, see Options.LowerRanges.
+	RangesPass = NewPass("ranges", &Options{LowerRanges: true})
+
+	// ChanRangesPass lowers range loops over channels, see Options.LowerChanRanges.
+	ChanRangesPass = NewPass("chanranges", &Options{LowerChanRanges: true})
+
+	// SwitchesPass lowers switch statements, see Options.LowerSwitches.
+	SwitchesPass = NewPass("switches", &Options{LowerSwitches: true})
+
+	// TypeSwitchesPass lowers type switch statements, see
+	// Options.LowerTypeSwitches.
+	TypeSwitchesPass = NewPass("typeswitches", &Options{LowerTypeSwitches: true})
+
+	// SelectOperandsPass hoists the operands of select statements, see
+	// Options.HoistSelectOperands.
+	SelectOperandsPass = NewPass("select", &Options{HoistSelectOperands: true})
+
+	// CallsPass moves nested calls into separate statements, lowering the
+	// operators && and || and splitting tuples as needed, see
+	// Options.SimplifyCalls.
+	CallsPass = NewPass("calls", &Options{SimplifyCalls: true, LowerShortCircuits: true, SplitTuples: true})
+)
+
+// DefaultPasses returns all built-in passes in an order that lets the later
+// passes simplify the code produced by the earlier ones.
+func DefaultPasses() []Pass {
+	return []Pass{RangesPass, ChanRangesPass, SwitchesPass, TypeSwitchesPass, SelectOperandsPass, CallsPass}
+}
+
+// A Pipeline runs a sequence of passes over the files of a package.
+type Pipeline struct {
+	Passes []Pass
+
+	// Verify, if non-nil, makes Run print and type-check the files again after
+	// each pass and report any errors to Verify.Error, prefixed with the name of
+	// the pass. No further passes run once a pass produced invalid code.
+	Verify *VerifyOptions
+}
+
+// Run applies the passes to all files of the type-checked package pkg, one pass
+// after the other. Each pass gets the type information returned by the previous
+// one. The returned files replace the given ones. If pkg is nil, Verify checks
+// the files as package main.
+func (p *Pipeline) Run(files []*ast.File, pkg *types.Package, info *types.Info) ([]*ast.File, *types.Info) {
+	path := "main"
+	if pkg != nil {
+		path = pkg.Path()
+	}
+	for _, pass := range p.Passes {
+		newFiles := make([]*ast.File, len(files))
+		for i, file := range files {
+			newFiles[i], info = pass.Run(file, info)
+		}
+		files = newFiles
+
+		if p.Verify == nil {
+			continue
+		}
+		errs := Verify(path, files, p.Verify)
+		if p.Verify.Error != nil {
+			for _, err := range errs {
+				p.Verify.Error(fmt.Errorf("%s: %w", pass.Name(), err))
+			}
+		}
+		if len(errs) != 0 {
+			break
+		}
+	}
+	return files, info
+}
@@ -0,0 +1,116 @@
+package astrewrite
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	forEachTestFile(t, func(name string, opts Options, fset *token.FileSet, inFile *ast.File, pkg *types.Package, typesInfo *types.Info) {
+		p := &Pipeline{
+			Passes: DefaultPasses(),
+			Verify: &VerifyOptions{
+				Fset: fset,
+				Error: func(err error) {
+					t.Errorf("%s: %s", name, err)
+				},
+			},
+		}
+		outFiles, _ := p.Run([]*ast.File{inFile}, pkg, typesInfo)
+		if len(outFiles) != 1 || outFiles[0] == inFile {
+			t.Errorf("%s: expected a new file", name)
+		}
+	})
+}
+
+type testPass struct {
+	name string
+	run  func(file *ast.File, info *types.Info) (*ast.File, *types.Info)
+}
+
+func (p *testPass) Name() string {
+	return p.name
+}
+
+func (p *testPass) Run(file *ast.File, info *types.Info) (*ast.File, *types.Info) {
+	return p.run(file, info)
+}
+
+func TestPipelineCustomPass(t *testing.T) {
+	src := `package main
+
+func main() {
+	f(g())
+}
+
+func f(int) {}
+func g() int { return 0 }
+`
+	fset := token.NewFileSet()
+	file := parse(t, fset, src)
+	pkg, typesInfo := typeCheck(t, fset, file)
+
+	var calls []string
+	var seen *ast.File
+	breaking := &testPass{"breaking", func(file *ast.File, info *types.Info) (*ast.File, *types.Info) {
+		calls = append(calls, "breaking")
+		seen = file
+		// call an undefined function instead of f
+		body := file.Decls[0].(*ast.FuncDecl).Body
+		body.List[len(body.List)-1].(*ast.ExprStmt).X.(*ast.CallExpr).Fun = ast.NewIdent("h")
+		return file, info
+	}}
+	never := &testPass{"never", func(file *ast.File, info *types.Info) (*ast.File, *types.Info) {
+		calls = append(calls, "never")
+		return file, info
+	}}
+
+	var errs []error
+	p := &Pipeline{
+		Passes: []Pass{CallsPass, breaking, never},
+		Verify: &VerifyOptions{
+			Fset: fset,
+			Error: func(err error) {
+				errs = append(errs, err)
+			},
+		},
+	}
+	outFiles, _ := p.Run([]*ast.File{file}, pkg, typesInfo)
+
+	if seen == file || len(seen.Decls[0].(*ast.FuncDecl).Body.List) != 2 {
+		t.Errorf("the custom pass did not get the output of the previous pass")
+	}
+	if outFiles[0] != seen {
+		t.Errorf("expected the output of the failing pass")
+	}
+	if strings.Join(calls, " ") != "breaking" {
+		t.Errorf("expected only the breaking pass to run, got %v", calls)
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "breaking: ") {
+		t.Fatalf("expected one error of the breaking pass, got %v", errs)
+	}
+	var typeErr types.Error
+	if !errors.As(errs[0], &typeErr) {
+		t.Errorf("expected a wrapped types.Error, got %T", errors.Unwrap(errs[0]))
+	}
+}
+
+func TestPipelineNilPackage(t *testing.T) {
+	fset := token.NewFileSet()
+	file := parse(t, fset, "package main; func main() { f(g()) }; func f(int) {}; func g() int { return 0 }")
+	_, typesInfo := typeCheck(t, fset, file)
+	p := &Pipeline{
+		Passes: []Pass{CallsPass},
+		Verify: &VerifyOptions{
+			Fset: fset,
+			Error: func(err error) {
+				t.Error(err)
+			},
+		},
+	}
+	p.Run([]*ast.File{file}, nil, typesInfo)
+}
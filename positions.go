@@ -0,0 +1,235 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// setPositions gives the generated nodes of file that have no positions those
+// of their origins in orig, see Options.SyntheticPositions. Generated imports
+// keep no positions, so that they stay in front of the other declarations.
+func (c *simplifyContext) setPositions(file, orig *ast.File) {
+	var docStarts, commentEnds map[ast.Node]token.Pos
+	if c.fset != nil {
+		docStarts, commentEnds = c.commentBounds(orig)
+	}
+	var stack []ast.Node
+	var last token.Pos // the greatest position so far
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			// A closing brace does not precede the end of the block's
+			// contents, which may stem from later nodes, e.g. the else branch
+			// of a lowered switch statement that holds the following clauses.
+			if block, ok := stack[len(stack)-1].(*ast.BlockStmt); ok && c.provenance[block] != nil && len(block.List) != 0 {
+				if end := block.List[len(block.List)-1].End(); end > block.Rbrace {
+					block.Rbrace = end
+				}
+			}
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if decl, ok := n.(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+			return false
+		}
+		var parent ast.Node
+		if len(stack) != 0 {
+			parent = stack[len(stack)-1]
+		}
+		stack = append(stack, n)
+		if origin, ok := c.provenance[n]; ok && origin.Pos().IsValid() {
+			c.setOriginPos(n, origin, parent, last, docStarts, commentEnds)
+		}
+		if n.Pos() > last {
+			last = n.Pos()
+		}
+		return true
+	})
+}
+
+// setOriginPos sets the positions of the generated node n, which has the
+// parent parent in the generated file and follows code up to the position last.
+func (c *simplifyContext) setOriginPos(n, origin, parent ast.Node, last token.Pos, docStarts, commentEnds map[ast.Node]token.Pos) {
+	// Closing braces get the position of the end of the origin, so that
+	// comments within the origin stay within the generated block.
+	start, end := origin.Pos(), origin.End()-1
+	if !origin.End().IsValid() || end < start {
+		end = start
+	}
+	if block, ok := n.(*ast.BlockStmt); ok {
+		// The doc comments of the origin, e.g. those of a case clause, go
+		// into the generated block instead of in front of it, unless the
+		// block is part of a statement generated from the same origin or
+		// the comments got printed already, e.g. with code that was
+		// duplicated for a fallthrough statement.
+		if pos, ok := docStarts[origin]; ok && !block.Lbrace.IsValid() && c.provenance[parent] != origin && pos >= last {
+			block.Lbrace = pos
+		}
+		if pos, ok := commentEnds[origin]; ok && pos > end {
+			end = pos
+		}
+	}
+	setPos(n, start, end)
+}
+
+// commentBounds associates the comments of file with its nodes like
+// ast.NewCommentMap does. It returns, for nodes with doc comments, the
+// position of the end of the line before the first of these comments and, for
+// nodes that contain comments, the end of the last of them.
+func (c *simplifyContext) commentBounds(file *ast.File) (docStarts, commentEnds map[ast.Node]token.Pos) {
+	parents := make(map[ast.Node]ast.Node)
+	var stack []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if len(stack) != 0 {
+			parents[n] = stack[len(stack)-1]
+		}
+		stack = append(stack, n)
+		return true
+	})
+
+	docStarts = make(map[ast.Node]token.Pos)
+	commentEnds = make(map[ast.Node]token.Pos)
+	for node, groups := range ast.NewCommentMap(c.fset, file, file.Comments) {
+		for _, group := range groups {
+			if group.End() <= node.Pos() {
+				tokFile := c.fset.File(group.Pos())
+				line := tokFile.Line(group.Pos())
+				if line == 1 {
+					continue
+				}
+				pos := tokFile.LineStart(line) - 1
+				if start, ok := docStarts[node]; !ok || pos < start {
+					docStarts[node] = pos
+				}
+				continue
+			}
+			for n := node; n != nil; n = parents[n] {
+				// A comment that follows a closing token stays behind the
+				// corresponding generated one, but case clauses have no such
+				// token.
+				if group.Pos() >= n.End() && !isClause(n) {
+					continue
+				}
+				if group.End() > commentEnds[n] {
+					commentEnds[n] = group.End()
+				}
+			}
+		}
+	}
+	return docStarts, commentEnds
+}
+
+// setPos sets the positions of the tokens of n that have none to start, except
+// for the closing brace of a block, which gets end.
+func setPos(n ast.Node, start, end token.Pos) {
+	set := func(p *token.Pos, pos token.Pos) {
+		if !p.IsValid() {
+			*p = pos
+		}
+	}
+	switch n := n.(type) {
+	case *ast.Ident:
+		set(&n.NamePos, start)
+	case *ast.BasicLit:
+		set(&n.ValuePos, start)
+	case *ast.Ellipsis:
+		set(&n.Ellipsis, start)
+	case *ast.CompositeLit:
+		set(&n.Lbrace, start)
+		set(&n.Rbrace, start)
+	case *ast.ParenExpr:
+		set(&n.Lparen, start)
+		set(&n.Rparen, start)
+	case *ast.IndexExpr:
+		set(&n.Lbrack, start)
+		set(&n.Rbrack, start)
+	case *ast.IndexListExpr:
+		set(&n.Lbrack, start)
+		set(&n.Rbrack, start)
+	case *ast.SliceExpr:
+		set(&n.Lbrack, start)
+		set(&n.Rbrack, start)
+	case *ast.TypeAssertExpr:
+		set(&n.Lparen, start)
+		set(&n.Rparen, start)
+	case *ast.CallExpr:
+		set(&n.Lparen, start)
+		set(&n.Rparen, start)
+	case *ast.StarExpr:
+		set(&n.Star, start)
+	case *ast.UnaryExpr:
+		set(&n.OpPos, start)
+	case *ast.BinaryExpr:
+		set(&n.OpPos, start)
+	case *ast.KeyValueExpr:
+		set(&n.Colon, start)
+	case *ast.ArrayType:
+		set(&n.Lbrack, start)
+	case *ast.StructType:
+		set(&n.Struct, start)
+	case *ast.FuncType:
+		set(&n.Func, start)
+	case *ast.InterfaceType:
+		set(&n.Interface, start)
+	case *ast.MapType:
+		set(&n.Map, start)
+	case *ast.ChanType:
+		set(&n.Begin, start)
+	case *ast.FieldList:
+		set(&n.Opening, start)
+		set(&n.Closing, start)
+	case *ast.EmptyStmt:
+		set(&n.Semicolon, start)
+	case *ast.LabeledStmt:
+		set(&n.Colon, start)
+	case *ast.SendStmt:
+		set(&n.Arrow, start)
+	case *ast.IncDecStmt:
+		set(&n.TokPos, start)
+	case *ast.AssignStmt:
+		set(&n.TokPos, start)
+	case *ast.GoStmt:
+		set(&n.Go, start)
+	case *ast.DeferStmt:
+		set(&n.Defer, start)
+	case *ast.ReturnStmt:
+		set(&n.Return, start)
+	case *ast.BranchStmt:
+		set(&n.TokPos, start)
+	case *ast.BlockStmt:
+		set(&n.Lbrace, start)
+		set(&n.Rbrace, end)
+	case *ast.IfStmt:
+		set(&n.If, start)
+	case *ast.CaseClause:
+		set(&n.Case, start)
+		set(&n.Colon, start)
+	case *ast.SwitchStmt:
+		set(&n.Switch, start)
+	case *ast.TypeSwitchStmt:
+		set(&n.Switch, start)
+	case *ast.CommClause:
+		set(&n.Case, start)
+		set(&n.Colon, start)
+	case *ast.SelectStmt:
+		set(&n.Select, start)
+	case *ast.ForStmt:
+		set(&n.For, start)
+	case *ast.RangeStmt:
+		set(&n.For, start)
+		set(&n.TokPos, start)
+	case *ast.GenDecl:
+		set(&n.TokPos, start)
+	}
+}
+
+func isClause(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.CaseClause, *ast.CommClause:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package astrewrite
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestSyntheticPositions(t *testing.T) {
+	src := `package main
+
+func main() {
+	// first
+	f(g(g(1))) // trailing
+	// before the loop
+	for i := range h() {
+		// inside the loop
+		f(i)
+	}
+	// last
+}
+
+func f(int)     {}
+func g(int) int { return 0 }
+func h() []int  { return nil }
+`
+	expected := `package main
+
+func main() {
+	// first
+	_1 := g(1)
+	_2 := g(_1)
+	f(_2) // trailing
+	// before the loop
+	for _3, _4 := h(), 0; _4 < len(_3); _4++ {
+		i := _4
+		// inside the loop
+		f(i)
+	}
+	// last
+}
+
+func f(int)     {}
+func g(int) int { return 0 }
+func h() []int  { return nil }
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, typesInfo := typeCheck(t, fset, file)
+	outFile := Simplify(file, typesInfo, allLowerings(Options{LowerRanges: true, SyntheticPositions: true}))
+	var buf bytes.Buffer
+	config := &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := config.Fprint(&buf, fset, outFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != expected {
+		t.Errorf("expected:\n%s\n--- got:\n%s", expected, got)
+	}
+}
+
+func TestSyntheticPositionsTestdata(t *testing.T) {
+	forEachTestFile(t, func(name string, opts Options, fset *token.FileSet, inFile *ast.File, pkg *types.Package, typesInfo *types.Info) {
+		opts.SyntheticPositions = true
+		outFile := Simplify(inFile, typesInfo, &opts)
+		ast.Inspect(outFile, func(n ast.Node) bool {
+			if decl, ok := n.(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+				return false
+			}
+			if n != nil && !n.Pos().IsValid() {
+				t.Errorf("%s: %T has no position", name, n)
+			}
+			return true
+		})
+	})
+}
+
+func TestPreserveComments(t *testing.T) {
+	src := `package main
+
+func main() {
+	// doc of x
+	var x = f(f(1)) // after x
+
+	switch f(x) {
+	// one is special
+	case 1:
+		p("one")
+		// end of one
+	// two and three
+	case 2, f(3): // trailing two
+		p("two")
+		fallthrough // falls
+	// the default
+	default:
+		p("default") // at the default
+	}
+	// the end
+}
+
+func f(x int) int { return x + 1 }
+func p(string)    {}
+`
+	expected := `package main
+
+func main() {
+	// doc of x
+	_1 := f(1)
+	_2 := f(_1)
+	var x = _2 // after x
+
+	switch {
+	default:
+		_3 := f(x)
+		// one is special
+		if _3 == (1) {
+			p("one")
+		} else {
+			// end of one
+			// two and three
+			_4 := _3 == (2)
+			if !_4 {
+				_5 := f(3)
+				_4 = _3 == (_5)
+			}
+			if _4 { // trailing two
+				p("two")
+				// falls
+				// the default
+
+				p("default")
+			} else {
+				p("default") // at the default
+			}
+		}
+	}
+	// the end
+}
+
+func f(x int) int { return x + 1 }
+func p(string)    {}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, typesInfo := typeCheck(t, fset, file)
+	outFile := Simplify(file, typesInfo, allLowerings(Options{PreserveComments: true, Fset: fset}))
+	var buf bytes.Buffer
+	if err := Fprint(&buf, fset, outFile, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != expected {
+		t.Errorf("expected:\n%s\n--- got:\n%s", expected, got)
+	}
+}
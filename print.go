@@ -0,0 +1,26 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"io"
+)
+
+// Fprint prints file to w, formatted like gofmt does.
+//
+// If lineDirectives is set, a //line directive naming the original file and
+// line is inserted wherever the printed code does not continue on the line that
+// follows from the previous one, so that compiler errors, vet reports and stack
+// traces of the printed code refer to the original source. Generated nodes
+// without positions count as belonging to the line of the code printed before
+// them; see Options.SyntheticPositions for giving them the lines of their
+// origins. The columns of such output are not aligned, since the alignment
+// breaks the indentation that follows a directive.
+func Fprint(w io.Writer, fset *token.FileSet, file *ast.File, lineDirectives bool) error {
+	config := &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if lineDirectives {
+		config.Mode = printer.RawFormat | printer.TabIndent | printer.SourcePos
+	}
+	return config.Fprint(w, fset, file)
+}
@@ -0,0 +1,69 @@
+package astrewrite
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestFprintLineDirectives(t *testing.T) {
+	src := `package main
+
+func main() {
+	switch x := g(1); x {
+	case g(2),
+		g(3):
+		g(4)
+	case g(g(
+		5)):
+	default:
+		g(6)
+	}
+}
+
+func g(int) int { return 0 }
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, typesInfo := typeCheck(t, fset, file)
+	lines := callLines(fset, file)
+
+	outFile := Simplify(file, typesInfo, allLowerings(Options{SyntheticPositions: true}))
+	var buf bytes.Buffer
+	if err := Fprint(&buf, fset, outFile, true); err != nil {
+		t.Fatal(err)
+	}
+	printedFset := token.NewFileSet()
+	printedFile, err := parser.ParseFile(printedFset, "printed.go", buf.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printedLines := callLines(printedFset, printedFile)
+	for arg, line := range lines {
+		if printedLines[arg] != line {
+			t.Errorf("expected the call g(%s) at %s, got %s in:\n%s", arg, line, printedLines[arg], buf.String())
+		}
+	}
+}
+
+// callLines returns the positions of the calls of g in file, keyed by their
+// arguments if these are literals.
+func callLines(fset *token.FileSet, file *ast.File) map[string]string {
+	lines := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+				pos := fset.Position(call.Pos())
+				lines[lit.Value] = fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+			}
+		}
+		return true
+	})
+	return lines
+}
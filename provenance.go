@@ -0,0 +1,101 @@
+package astrewrite
+
+import (
+	"go/ast"
+)
+
+// Provenance maps the nodes created by Simplify to the nodes of the original
+// file that they were generated from, see Options.Provenance.
+type Provenance map[ast.Node]ast.Node
+
+// Origin returns the node of the original file that n was generated from, or n
+// itself if it is not a generated node.
+func (p Provenance) Origin(n ast.Node) ast.Node {
+	if origin, ok := p[n]; ok {
+		return origin
+	}
+	return n
+}
+
+// collectOriginals records the nodes of file, which is about to be simplified,
+// so that generated nodes can be told apart from reused ones.
+func (c *simplifyContext) collectOriginals(file *ast.File) {
+	if c.provenance == nil {
+		return
+	}
+	c.originals = make(map[ast.Node]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n != nil {
+			c.originals[n] = true
+		}
+		return true
+	})
+}
+
+// resolveOrigin returns the original node that origin stands for, or nil if it
+// is a generated node that has no origin yet. Nodes generated by an earlier run
+// with the same provenance map resolve to their origin in that run.
+func (c *simplifyContext) resolveOrigin(origin ast.Node) ast.Node {
+	if o, ok := c.provenance[origin]; ok {
+		return o
+	}
+	if c.originals[origin] {
+		return origin
+	}
+	return nil
+}
+
+// setOrigin records origin as the origin of nodes and of all generated nodes
+// within them that have none yet. Since the nodes generated while simplifying a
+// part of a statement or an expression get recorded first, each of them ends
+// up with the innermost original node that it was generated from.
+func (c *simplifyContext) setOrigin(origin ast.Node, nodes ...ast.Node) {
+	if c.provenance == nil {
+		return
+	}
+	origin = c.resolveOrigin(origin)
+	if origin == nil {
+		return
+	}
+	for _, node := range nodes {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if n == nil || c.originals[n] {
+				return false
+			}
+			if _, ok := c.provenance[n]; !ok {
+				record(c, c.provenance, n, origin)
+			}
+			return true
+		})
+	}
+}
+
+// trackOrigin makes node the origin of the statements appended to *stmts until
+// the returned function is called, as well as of the nodes passed to it. It
+// also makes node the current origin in the meantime, which generated imports
+// get attributed to. A generated node without an origin stands for the current
+// origin.
+func (c *simplifyContext) trackOrigin(stmts *[]ast.Stmt, node ast.Node) func(nodes ...ast.Node) {
+	if c.provenance == nil {
+		return func(...ast.Node) {}
+	}
+	n := 0
+	if stmts != nil {
+		n = len(*stmts)
+	}
+	outer := c.origin
+	origin := c.resolveOrigin(node)
+	if origin == nil {
+		origin = outer
+	}
+	c.origin = origin
+	return func(nodes ...ast.Node) {
+		c.origin = outer
+		if stmts != nil {
+			for _, s := range (*stmts)[n:] {
+				c.setOrigin(origin, s)
+			}
+		}
+		c.setOrigin(origin, nodes...)
+	}
+}
@@ -0,0 +1,129 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	src := `package main
+
+func main() {
+	f(g())
+	switch x := g(); x {
+	case 1, g():
+		f(x)
+	default:
+	}
+	for v := range make(chan int) {
+		f(v)
+	}
+	for i, r := range "abc" {
+		f(i + int(r))
+	}
+}
+
+func f(int) {}
+func g() int { return 0 }
+`
+	fset := token.NewFileSet()
+	file := parse(t, fset, src)
+	_, typesInfo := typeCheck(t, fset, file)
+	originals := make(map[ast.Node]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		originals[n] = true
+		return true
+	})
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	gCall := body.List[0].(*ast.ExprStmt).X.(*ast.CallExpr).Args[0]
+	switchStmt := body.List[1].(*ast.SwitchStmt)
+	chanRange := body.List[2]
+
+	provenance := make(Provenance)
+	outFile := Simplify(file, typesInfo, allLowerings(Options{LowerRanges: true, Provenance: provenance}))
+	checkProvenance(t, outFile, originals, provenance)
+
+	var outBody *ast.BlockStmt
+	for _, decl := range outFile.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok && decl.Name.Name == "main" {
+			outBody = decl.Body
+		}
+	}
+
+	// the temporary variable holding the result of g()
+	temp := outBody.List[0].(*ast.AssignStmt)
+	if provenance[temp] != gCall || provenance[temp.Lhs[0]] != gCall {
+		t.Errorf("expected the temporary variable to originate from %v, got %v", gCall, provenance[temp])
+	}
+
+	// the if statements generated for the case clause and for the || of its
+	// case expressions
+	clause := switchStmt.Body.List[0].(*ast.CaseClause)
+	var ifStmts []*ast.IfStmt
+	ast.Inspect(outBody.List[2], func(n ast.Node) bool {
+		if s, ok := n.(*ast.IfStmt); ok {
+			ifStmts = append(ifStmts, s)
+		}
+		return true
+	})
+	if len(ifStmts) != 2 {
+		t.Fatalf("expected 2 if statements, got %d", len(ifStmts))
+	}
+	if provenance[ifStmts[0]] != clause.List[0] {
+		t.Errorf("expected the first if statement to originate from the first case expression, got %T", provenance[ifStmts[0]])
+	}
+	if provenance[ifStmts[1]] != clause {
+		t.Errorf("expected the second if statement to originate from the case clause, got %T", provenance[ifStmts[1]])
+	}
+
+	// the loop receiving from the channel
+	var forStmt *ast.ForStmt
+	ast.Inspect(outBody, func(n ast.Node) bool {
+		if s, ok := n.(*ast.ForStmt); ok && forStmt == nil {
+			forStmt = s
+		}
+		return true
+	})
+	if forStmt == nil || provenance[forStmt] != chanRange {
+		t.Errorf("expected the for statement to originate from the range statement over the channel")
+	}
+
+	// simplifying again keeps pointing to the original file
+	outFile2 := Simplify(outFile, typesInfo, &Options{SimplifyCalls: true, Provenance: provenance})
+	checkProvenance(t, outFile2, originals, provenance)
+}
+
+// checkProvenance checks that every node of outFile that is not in originals
+// maps to a node that is.
+func checkProvenance(t *testing.T, outFile *ast.File, originals map[ast.Node]bool, provenance Provenance) {
+	t.Helper()
+	ast.Inspect(outFile, func(n ast.Node) bool {
+		if n == nil || originals[n] {
+			return false
+		}
+		origin, ok := provenance[n]
+		if !ok {
+			t.Errorf("generated %T has no origin", n)
+			return true
+		}
+		if !originals[origin] {
+			t.Errorf("generated %T originates from generated %T", n, origin)
+		}
+		return true
+	})
+}
+
+func TestProvenanceTestdata(t *testing.T) {
+	forEachTestFile(t, func(name string, opts Options, fset *token.FileSet, inFile *ast.File, pkg *types.Package, typesInfo *types.Info) {
+		originals := make(map[ast.Node]bool)
+		ast.Inspect(inFile, func(n ast.Node) bool {
+			originals[n] = true
+			return true
+		})
+
+		opts.Provenance = make(Provenance)
+		checkProvenance(t, Simplify(inFile, typesInfo, &opts), originals, opts.Provenance)
+	})
+}
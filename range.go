@@ -0,0 +1,481 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+)
+
+// lowerRange turns a range loop over a slice, array, pointer to array, string,
+// map, integer or function into a loop without a range clause. It reports false
+// and emits nothing if s can not be lowered.
+func (c *simplifyContext) lowerRange(stmts *[]ast.Stmt, s *ast.RangeStmt) bool {
+	t := coreType(c.info.TypeOf(s.X))
+	if p, ok := t.(*types.Pointer); ok {
+		t = coreType(p.Elem())
+		if _, ok := t.(*types.Array); !ok {
+			return false
+		}
+	}
+	switch t := t.(type) {
+	case *types.Slice:
+		return c.lowerIndexRange(stmts, s, t.Elem(), -1)
+	case *types.Array:
+		return c.lowerIndexRange(stmts, s, t.Elem(), t.Len())
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsString != 0:
+			return c.lowerStringRange(stmts, s)
+		case t.Info()&types.IsInteger != 0:
+			return c.lowerIntRange(stmts, s)
+		}
+	case *types.Map:
+		return c.lowerMapRange(stmts, s, t)
+	case *types.Signature:
+		return c.lowerFuncRange(stmts, s, t)
+	}
+	return false
+}
+
+// lowerIndexRange lowers a range loop over a slice, array or pointer to array
+// into a loop over the indices. The range expression is not evaluated if length
+// is not negative, which is the length of the array, and only the index is used,
+// as in the original loop. The bound is then the constant len of the range
+// expression, which keeps the variables in it used.
+func (c *simplifyContext) lowerIndexRange(stmts *[]ast.Stmt, s *ast.RangeStmt, elem types.Type, length int64) bool {
+	shared := c.sharesIterVars(s)
+	if !c.isUniverse("len") || shared && !c.canZeroIterVars(s) {
+		return false
+	}
+
+	init := &ast.AssignStmt{Tok: token.DEFINE}
+	var x *ast.Ident
+	var n ast.Expr
+	if length < 0 || !isBlank(s.Value) || ContainsBlockingOp(s.X) {
+		x = c.initVar(init, types.Default(c.info.TypeOf(s.X)), s.X.Pos(), c.simplifyExpr2(stmts, s.X, true))
+		n = c.builtinCall("len", types.Typ[types.Int], c.ref(x))
+	} else {
+		n = c.builtinCall("len", types.Typ[types.Int], c.simplifyExpr(stmts, s.X))
+		c.recordType(n, types.TypeAndValue{Type: types.Typ[types.Int], Value: constant.MakeInt64(length)})
+	}
+	i := c.initVar(init, types.Typ[types.Int], s.For, c.intConst(0))
+
+	var prefix []ast.Stmt
+	c.assignIterVars(&prefix, s, shared, c.ref(i), func() ast.Expr {
+		return c.setType(&ast.IndexExpr{X: c.ref(x), Index: c.ref(i)}, elem)
+	})
+
+	newS := &ast.ForStmt{
+		For:  s.For,
+		Init: c.loopInit(s, shared, init),
+		Cond: c.setType(&ast.BinaryExpr{X: c.ref(i), Op: token.LSS, Y: n}, types.Typ[types.Bool]),
+		Post: &ast.IncDecStmt{X: c.ref(i), Tok: token.INC},
+		Body: c.iterationBody(s, shared, prefix, c.simplifyBlock(s.Body)),
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	*stmts = append(*stmts, newS)
+	return true
+}
+
+// lowerIntRange lowers a range loop over an integer into a counting loop. The
+// counter has the type of the iteration variable, which is int for an untyped
+// constant.
+func (c *simplifyContext) lowerIntRange(stmts *[]ast.Stmt, s *ast.RangeStmt) bool {
+	t := types.Default(c.info.TypeOf(s.X))
+	if !isBlank(s.Key) {
+		t = c.info.TypeOf(s.Key)
+	}
+	shared := c.sharesIterVars(s)
+	if !types.Identical(t, types.Typ[types.Int]) && (!c.isUniverse("new") || !c.canDenote(t)) || shared && !c.canZeroIterVars(s) {
+		return false
+	}
+
+	init := &ast.AssignStmt{Tok: token.DEFINE}
+	var n ast.Expr
+	if c.info.Types[s.X].Value != nil {
+		n = c.simplifyExpr(stmts, s.X)
+	} else {
+		n = c.ref(c.initVar(init, types.Default(c.info.TypeOf(s.X)), s.X.Pos(), c.simplifyExpr2(stmts, s.X, true)))
+	}
+	i := c.initVar(init, t, s.For, c.zeroValue(t, s.For))
+
+	var prefix []ast.Stmt
+	c.assignIterVars(&prefix, s, shared, c.ref(i), nil)
+
+	newS := &ast.ForStmt{
+		For:  s.For,
+		Init: c.loopInit(s, shared, init),
+		Cond: c.setType(&ast.BinaryExpr{X: c.ref(i), Op: token.LSS, Y: n}, types.Typ[types.Bool]),
+		Post: &ast.IncDecStmt{X: c.ref(i), Tok: token.INC},
+		Body: c.iterationBody(s, shared, prefix, c.simplifyBlock(s.Body)),
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	*stmts = append(*stmts, newS)
+	return true
+}
+
+// lowerStringRange lowers a range loop over a string into a loop that decodes
+// one rune per iteration with utf8.DecodeRuneInString. Like the range loop, it
+// yields utf8.RuneError and advances by one byte for invalid UTF-8.
+func (c *simplifyContext) lowerStringRange(stmts *[]ast.Stmt, s *ast.RangeStmt) bool {
+	shared := c.sharesIterVars(s)
+	if !c.isUniverse("len") || !c.isUniverse("string") || shared && !c.canZeroIterVars(s) {
+		return false
+	}
+	utf8 := c.lookupPackage("unicode/utf8")
+	if utf8 == nil {
+		return false
+	}
+	decode, ok := utf8.Scope().Lookup("DecodeRuneInString").(*types.Func)
+	if !ok {
+		return false
+	}
+	results := decode.Type().(*types.Signature).Results()
+
+	init := &ast.AssignStmt{Tok: token.DEFINE}
+	x := c.initVar(init, types.Default(c.info.TypeOf(s.X)), s.X.Pos(), c.simplifyExpr2(stmts, s.X, true))
+	i := c.initVar(init, types.Typ[types.Int], s.For, c.intConst(0))
+
+	var prefix []ast.Stmt
+	var r *ast.Ident
+	if isBlank(s.Value) {
+		r = c.newBlankIdent(results.At(0).Type(), s.For)
+	} else {
+		r = c.newIdent(results.At(0).Type(), s.For)
+	}
+	width := c.newIdent(results.At(1).Type(), s.For)
+	var rest ast.Expr = c.setType(&ast.SliceExpr{X: c.ref(x), Low: c.ref(i)}, c.info.TypeOf(x))
+	if !types.Identical(c.info.TypeOf(x), types.Typ[types.String]) {
+		rest = c.setType(&ast.CallExpr{Fun: c.universeIdent("string"), Args: []ast.Expr{rest}}, types.Typ[types.String])
+	}
+	prefix = append(prefix, &ast.AssignStmt{
+		Lhs: []ast.Expr{r, width},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{c.setType(&ast.CallExpr{
+			Fun:  c.qualifiedIdent(utf8, "DecodeRuneInString"),
+			Args: []ast.Expr{rest},
+		}, results)},
+	})
+	c.assignIterVars(&prefix, s, shared, c.ref(i), func() ast.Expr {
+		return c.ref(r)
+	})
+	prefix = append(prefix, simpleAssign(c.ref(i), token.ADD_ASSIGN, c.ref(width)))
+
+	newS := &ast.ForStmt{
+		For:  s.For,
+		Init: c.loopInit(s, shared, init),
+		Cond: c.setType(&ast.BinaryExpr{
+			X:  c.ref(i),
+			Op: token.LSS,
+			Y:  c.builtinCall("len", types.Typ[types.Int], c.ref(x)),
+		}, types.Typ[types.Bool]),
+		Body: c.iterationBody(s, shared, prefix, c.simplifyBlock(s.Body)),
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	*stmts = append(*stmts, newS)
+	return true
+}
+
+// lowerChanRange lowers a range loop over a channel into a loop that receives
+// from the channel and breaks once it is closed. The iteration variable is only
+// assigned if a value was received. It reports false and emits nothing if s can
+// not be lowered.
+func (c *simplifyContext) lowerChanRange(stmts *[]ast.Stmt, s *ast.RangeStmt, t *types.Chan) bool {
+	shared := c.sharesIterVars(s)
+	if shared && !c.canZeroIterVars(s) {
+		return false
+	}
+
+	leave := c.enterScope(s.Body)
+	key := s.Key
+	assign := false
+	switch {
+	case key == nil:
+		key = c.newBlankIdent(t.Elem(), s.For)
+	case s.Tok == token.ASSIGN && !isBlank(key) || shared:
+		key = c.newIdent(t.Elem(), s.For)
+		assign = true
+	}
+	okVar := c.newIdent(types.Typ[types.Bool], s.For)
+	leave()
+
+	var init ast.Stmt
+	if shared {
+		init = simpleAssign(s.Key, token.DEFINE, c.zeroValue(c.info.TypeOf(s.Key), s.Key.Pos()))
+	}
+
+	tok := token.DEFINE
+	if s.Tok == token.ASSIGN && !assign {
+		tok = token.ASSIGN
+	}
+	var prefix []ast.Stmt
+	c.simplifyStmt(&prefix, &ast.AssignStmt{
+		Lhs:    []ast.Expr{key, okVar},
+		TokPos: s.TokPos,
+		Tok:    tok,
+		Rhs: []ast.Expr{c.setType(&ast.UnaryExpr{
+			Op: token.ARROW,
+			X:  c.newVar(stmts, c.simplifyExpr2(stmts, s.X, true), s.X),
+		}, types.NewTuple(
+			types.NewVar(token.NoPos, nil, "", t.Elem()),
+			types.NewVar(token.NoPos, nil, "", types.Typ[types.Bool]),
+		))},
+	})
+	prefix = append(prefix, &ast.IfStmt{
+		Cond: c.negate(c.ref(okVar)),
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.BranchStmt{Tok: token.BREAK},
+			},
+		},
+	})
+	if assign {
+		c.assignIterVars(&prefix, s, shared, c.ref(key.(*ast.Ident)), nil)
+	}
+
+	newS := &ast.ForStmt{
+		For:  s.For,
+		Init: init,
+		Body: c.iterationBody(s, shared, prefix, c.simplifyBlock(s.Body)),
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	*stmts = append(*stmts, newS)
+	return true
+}
+
+// lowerMapRange lowers a range loop over a map into a loop over the
+// reflect.MapIter of the map, which visits the entries like the range loop
+// does. The keys and values get converted back with comma-ok type assertions,
+// so that nil interfaces yield nil.
+func (c *simplifyContext) lowerMapRange(stmts *[]ast.Stmt, s *ast.RangeStmt, t *types.Map) bool {
+	shared := c.sharesIterVars(s)
+	if !isBlank(s.Key) && !c.canDenote(t.Key()) || !isBlank(s.Value) && !c.canDenote(t.Elem()) || shared && !c.canZeroIterVars(s) {
+		return false
+	}
+	if shared {
+		// the type assertions in the body must not refer to the iteration variables
+		leave := c.enterScope(s)
+		ok := (isBlank(s.Key) || c.canDenote(t.Key())) && (isBlank(s.Value) || c.canDenote(t.Elem()))
+		leave()
+		if !ok {
+			return false
+		}
+	}
+	reflect := c.lookupPackage("reflect")
+	if reflect == nil {
+		return false
+	}
+	valueOf, ok := reflect.Scope().Lookup("ValueOf").(*types.Func)
+	if !ok {
+		return false
+	}
+
+	temp := func(stmts *[]ast.Stmt, x ast.Expr) *ast.Ident {
+		id := c.newIdent(c.info.TypeOf(x), s.For)
+		*stmts = append(*stmts, simpleAssign(id, token.DEFINE, x))
+		return id
+	}
+	init := &ast.AssignStmt{Tok: token.DEFINE}
+	mapRange := c.methodCall(c.setType(&ast.CallExpr{
+		Fun:  c.qualifiedIdent(reflect, "ValueOf"),
+		Args: []ast.Expr{c.simplifyExpr(stmts, s.X)},
+	}, valueOf.Type().(*types.Signature).Results().At(0).Type()), "MapRange")
+	iter := c.initVar(init, c.info.TypeOf(mapRange), s.For, mapRange)
+
+	var prefix []ast.Stmt
+	next := temp(&prefix, c.methodCall(c.ref(iter), "Next"))
+	prefix = append(prefix, &ast.IfStmt{
+		Cond: c.negate(c.ref(next)),
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.BranchStmt{Tok: token.BREAK},
+			},
+		},
+	})
+	entry := func(method string, t types.Type, typ ast.Expr) ast.Expr {
+		x := temp(&prefix, c.methodCall(c.ref(iter), method))
+		x = temp(&prefix, c.methodCall(c.ref(x), "Interface"))
+		id := c.newIdent(t, s.For)
+		prefix = append(prefix, &ast.AssignStmt{
+			Lhs: []ast.Expr{id, c.newBlankIdent(types.Typ[types.Bool], s.For)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{c.setType(&ast.TypeAssertExpr{
+				X:    c.ref(x),
+				Type: typ,
+			}, types.NewTuple(
+				types.NewVar(token.NoPos, nil, "", t),
+				types.NewVar(token.NoPos, nil, "", types.Typ[types.Bool]),
+			))},
+		})
+		return c.ref(id)
+	}
+	// the types get denoted in the scope of the loop, before the iteration
+	// variables get declared
+	var key, elem ast.Expr
+	if !isBlank(s.Key) {
+		key = entry("Key", t.Key(), c.typeExpr(t.Key(), s.For))
+	}
+	if !isBlank(s.Value) {
+		elem = c.typeExpr(t.Elem(), s.For)
+	}
+	c.assignIterVars(&prefix, s, shared, key, func() ast.Expr {
+		return entry("Value", t.Elem(), elem)
+	})
+
+	newS := &ast.ForStmt{
+		For:  s.For,
+		Init: c.loopInit(s, shared, init),
+		Body: c.iterationBody(s, shared, prefix, c.simplifyBlock(s.Body)),
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	*stmts = append(*stmts, newS)
+	return true
+}
+
+// lowerFuncRange lowers a range loop over an iterator function into a call of
+// the function. Unlike the range loop, the lowered code does not check that the
+// iterator stops calling the yield function once it returned false.
+func (c *simplifyContext) lowerFuncRange(stmts *[]ast.Stmt, s *ast.RangeStmt, t *types.Signature) bool {
+	if t.Params().Len() != 1 {
+		return false
+	}
+	yield, ok := coreType(t.Params().At(0).Type()).(*types.Signature)
+	if !ok {
+		return false
+	}
+	paramTypes := make([]types.Type, yield.Params().Len())
+	for i := range paramTypes {
+		paramTypes[i] = yield.Params().At(i).Type()
+	}
+	return c.lowerToYield(stmts, s, paramTypes, func(stmts *[]ast.Stmt) ast.Expr {
+		return c.simplifyExpr(stmts, s.X)
+	})
+}
+
+// sharesIterVars reports whether s declares iteration variables that are
+// shared by all iterations, as they are before Go 1.22.
+func (c *simplifyContext) sharesIterVars(s *ast.RangeStmt) bool {
+	return !c.perIteration && s.Tok == token.DEFINE && (!isBlank(s.Key) || !isBlank(s.Value))
+}
+
+// canZeroIterVars reports whether zeroValue can be used for the iteration
+// variables of s.
+func (c *simplifyContext) canZeroIterVars(s *ast.RangeStmt) bool {
+	if !c.isUniverse("new") {
+		return false
+	}
+	for _, x := range []ast.Expr{s.Key, s.Value} {
+		if !isBlank(x) && !c.canDenote(c.info.TypeOf(x)) {
+			return false
+		}
+	}
+	return true
+}
+
+// initVar declares a new temporary variable of type t with the value x in init,
+// the init statement of a loop that lowers a range loop. Unlike temporary
+// variables in front of the loop, it does not make a goto statement that jumps
+// over the loop invalid.
+func (c *simplifyContext) initVar(init *ast.AssignStmt, t types.Type, pos token.Pos, x ast.Expr) *ast.Ident {
+	id := c.newIdent(t, pos)
+	init.Lhs = append(init.Lhs, id)
+	init.Rhs = append(init.Rhs, x)
+	return id
+}
+
+// loopInit returns init, the init statement of a loop that lowers s, or nil if
+// it declares nothing. Shared iteration variables get declared along with the
+// variables of init.
+func (c *simplifyContext) loopInit(s *ast.RangeStmt, shared bool, init *ast.AssignStmt) ast.Stmt {
+	if shared {
+		for _, x := range []ast.Expr{s.Key, s.Value} {
+			if !isBlank(x) {
+				init.Lhs = append(init.Lhs, x)
+				init.Rhs = append(init.Rhs, c.zeroValue(c.info.TypeOf(x), x.Pos()))
+			}
+		}
+	}
+	if len(init.Lhs) == 0 {
+		return nil
+	}
+	return init
+}
+
+// assignIterVars appends the assignment of key and the value returned by value
+// to the iteration variables of s. The value is only computed if it is used.
+// Shared iteration variables are declared elsewhere, so they only get assigned.
+func (c *simplifyContext) assignIterVars(stmts *[]ast.Stmt, s *ast.RangeStmt, shared bool, key ast.Expr, value func() ast.Expr) {
+	defer c.enterScope(s)()
+	tok := s.Tok
+	if shared {
+		tok = token.ASSIGN
+	}
+	var lhs, rhs []ast.Expr
+	if !isBlank(s.Key) {
+		lhs = append(lhs, c.iterVar(s.Key, shared))
+		rhs = append(rhs, key)
+	}
+	if !isBlank(s.Value) {
+		lhs = append(lhs, c.iterVar(s.Value, shared))
+		rhs = append(rhs, value())
+	}
+	if len(lhs) == 0 {
+		return
+	}
+	c.simplifyStmt(stmts, &ast.AssignStmt{
+		Lhs:    lhs,
+		Tok:    tok,
+		TokPos: s.TokPos,
+		Rhs:    rhs,
+	})
+}
+
+// iterVar returns the iteration variable x for the left-hand side of an assignment.
+func (c *simplifyContext) iterVar(x ast.Expr, shared bool) ast.Expr {
+	if shared {
+		return c.ref(x.(*ast.Ident))
+	}
+	return x
+}
+
+// iterationBody returns the body of a lowered range loop, which runs prefix
+// before the simplified original body. The original body gets nested in a block
+// of its own if it declares a name that is also declared by the prefix.
+func (c *simplifyContext) iterationBody(s *ast.RangeStmt, shared bool, prefix []ast.Stmt, body *ast.BlockStmt) *ast.BlockStmt {
+	newBody := &ast.BlockStmt{
+		Lbrace: body.Lbrace,
+		List:   append(prefix, body.List...),
+		Rbrace: body.Rbrace,
+	}
+	if scope := c.info.Scopes[s.Body]; scope != nil && s.Tok == token.DEFINE && !shared {
+		for _, x := range []ast.Expr{s.Key, s.Value} {
+			if !isBlank(x) && scope.Lookup(x.(*ast.Ident).Name) != nil {
+				newBody.List = append(prefix, body)
+				return newBody
+			}
+		}
+	}
+	c.recordScope(newBody, c.info.Scopes[s.Body])
+	return newBody
+}
+
+// zeroValue returns an expression for the zero value of t, which must be
+// denotable, see canDenote.
+func (c *simplifyContext) zeroValue(t types.Type, pos token.Pos) ast.Expr {
+	switch {
+	case types.Identical(t, types.Typ[types.Int]):
+		return c.intConst(0)
+	case types.Identical(t, types.Typ[types.Bool]):
+		return c.boolConst(false)
+	}
+	return c.setType(&ast.StarExpr{
+		X: c.builtinCall("new", types.NewPointer(t), c.typeExpr(t, pos)),
+	}, t)
+}
+
+// isBlank reports whether x is missing or the blank identifier.
+func isBlank(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	return x == nil || ok && id.Name == "_"
+}
@@ -6,46 +6,307 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
+	"go/version"
+	"strconv"
 )
 
+// Options configures the transformations applied by Simplify and SimplifyPackage.
+type Options struct {
+	// SimplifyCalls moves calls that are nested in other expressions into
+	// separate statements, storing their results in temporary variables. The
+	// operators && and || get moved as a whole if their right operand contains
+	// calls, unless LowerShortCircuits is set. Calls in the case expressions of
+	// switch statements stay in place, unless LowerSwitches is set. The calls in
+	// the value of a package-level variable declaration move into a function
+	// literal that gets called instead. A value whose type can not be denoted in
+	// the package, e.g. an unexported type of another package, stays as it is,
+	// which SimplifyPackageDiagnostics reports by a Diagnostic.
+	SimplifyCalls bool
+
+	// HoistReceives makes SimplifyCalls treat receive operations like calls,
+	// since both may block, see ContainsBlockingOp. A receive operation gets
+	// moved into a separate statement unless it is the outermost expression of
+	// a statement, and && and || get lowered if their right operand contains
+	// one. It only applies together with SimplifyCalls.
+	HoistReceives bool
+
+	// LowerShortCircuits turns the operators && and || into if statements if
+	// their right operand contains calls, so that these calls get moved as
+	// well. It only applies together with SimplifyCalls.
+	LowerShortCircuits bool
+
+	// SplitTuples passes the results of a call that is the only argument of
+	// another call, the only result of a return statement or the only value of
+	// a local variable declaration in separate temporary variables. The values
+	// of package-level variable declarations stay together, since there is no
+	// statement before them to declare the temporary variables in. It only
+	// applies together with SimplifyCalls.
+	SplitTuples bool
+
+	// LowerSwitches turns expression switch statements into chains of if
+	// statements.
+	LowerSwitches bool
+
+	// LowerTypeSwitches turns type switch statements into chains of if
+	// statements with comma-ok type assertions. A type switch that declares a
+	// variable is only lowered if the Implicits map of the type information is
+	// available.
+	LowerTypeSwitches bool
+
+	// LowerChanRanges turns range loops over channels into loops that receive
+	// from the channel until it is closed.
+	LowerChanRanges bool
+
+	// HoistSelectOperands stores the channels and the values to send of the
+	// cases of select statements in temporary variables in front of the
+	// statement, in the order in which they get evaluated.
+	HoistSelectOperands bool
+
+	// TempPrefix is the prefix of the names of temporary variables. It must be
+	// a valid identifier and defaults to "_". A counter is appended to the prefix
+	// and names that are already declared in the surrounding or any nested
+	// scope are skipped. Simplify and SimplifyPackage panic on an invalid
+	// prefix, SimplifyPackageDiagnostics returns an error. CheckTempPrefix
+	// checks it in advance.
+	TempPrefix string
+
+	// LowerRanges turns range loops over slices, arrays, pointers to arrays,
+	// strings, maps, integers and functions into loops without a range clause.
+	// Strings get decoded with the package unicode/utf8. Maps get iterated with
+	// reflect.MapIter. Functions get called with a function literal holding the
+	// loop body, which returns false to stop the iteration. The calls deferred
+	// in such a body still run when the surrounding function returns, but a
+	// call of recover in them does not stop a panic. Loops that would make a
+	// package import itself, like those over strings in unicode/utf8 or over
+	// maps in the packages that reflect imports, are kept. The iteration
+	// variables stay shared by all iterations if the file is older than Go 1.22
+	// according to info.FileVersions, or else the Go version of the package.
+	LowerRanges bool
+
+	// Importer loads the packages that lowered code refers to, such as
+	// unicode/utf8, unless the package already imports them, directly or
+	// indirectly. It defaults to importer.Default().
+	Importer types.Importer
+
+	// Verify, if non-nil, makes SimplifyPackage print and type-check the
+	// simplified files again and report any errors to Verify.Error. This catches
+	// rewrites that do not produce valid Go code.
+	Verify *VerifyOptions
+
+	// CopyInfo leaves the given types.Info unchanged, including the
+	// initializers of its InitOrder and the scopes of its Scopes, as well as
+	// the package scope and the imports of the package. The simplified files
+	// get described by a copy of it instead, as returned by SimplifyPackage.
+	// This way, the same type-checked package can be simplified several times.
+	// Simplify does not return the copy and therefore rejects CopyInfo.
+	CopyInfo bool
+
+	// Provenance, if non-nil, receives an entry for every node created by the
+	// simplification, which maps it to the node of the original file that it
+	// was generated from, e.g. a temporary variable to the call whose result it
+	// holds. Nodes that are taken over from the original file unchanged get no
+	// entry. Simplifying the result again with the same map keeps the entries
+	// pointing to the first original file.
+	Provenance Provenance
+
+	// SyntheticPositions gives the tokens of generated nodes the positions of
+	// the original nodes that they were generated from, see Provenance, instead
+	// of token.NoPos. A temporary variable and the statement declaring it get
+	// the position of the expression whose value it holds. Closing tokens get
+	// the position of the end of the original node. This way, go/printer keeps
+	// comments next to the code that they belong to, and compiled code reports
+	// lines of the original source.
+	SyntheticPositions bool
+
+	// PreserveComments keeps each comment of the file with the code generated
+	// from the node that it belongs to according to ast.CommentMap when the
+	// result gets printed, e.g. the comments of the case clauses of a lowered
+	// switch statement stay in the corresponding if statements. Doc comments
+	// of a statement precede the first statement generated from it, which may
+	// be a temporary variable. It implies SyntheticPositions and requires Fset.
+	PreserveComments bool
+
+	// Fset is the file set of the original file. It is only used by
+	// PreserveComments.
+	Fset *token.FileSet
+}
+
 type simplifyContext struct {
+	pkg           *types.Package
 	info          *types.Info
+	initializers  map[ast.Expr]*types.Initializer
+	scope         *types.Scope
 	varCounter    int
 	simplifyCalls bool
+	hoistReceives bool
+	tempPrefix    string
+	importer      types.Importer
+	verifyOpts    *VerifyOptions
+	copyInfo      bool
+	provenance    Provenance
+	positions     bool
+	diagnostics   *[]Diagnostic  // set by SimplifyPackageDiagnostics
+	fset          *token.FileSet // set if comments are preserved
+
+	lowerShortCircuits  bool
+	splitTuples         bool
+	lowerSwitches       bool
+	lowerTypeSwitches   bool
+	lowerChanRanges     bool
+	hoistSelectOperands bool
+	lowerRanges         bool
+
+	packages     map[string]*types.Package
+	fileScope    *types.Scope
+	fileImports  map[string]*types.PkgName
+	newImports   []*ast.ImportSpec
+	perIteration bool
+	funcSig      *types.Signature
+	funcBody     *ast.BlockStmt
+	loopLabels   map[ast.Stmt]*ast.Ident
+	pending      *pendingScopes    // set by simplifyDeclOrReport
+	originals    map[ast.Node]bool // nodes of the original file, see Options.Provenance
+	origin       ast.Node          // original node currently being simplified
 }
 
-func Simplify(file *ast.File, info *types.Info, simplifyCalls bool) *ast.File {
-	c := &simplifyContext{info: info, simplifyCalls: simplifyCalls}
+func newSimplifyContext(pkg *types.Package, info *types.Info, opts *Options) *simplifyContext {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.CopyInfo {
+		info = copyInfo(info)
+	}
+	c := &simplifyContext{
+		pkg:           pkg,
+		info:          info,
+		initializers:  make(map[ast.Expr]*types.Initializer),
+		simplifyCalls: opts.SimplifyCalls,
+		hoistReceives: opts.HoistReceives,
+		tempPrefix:    opts.TempPrefix,
+		importer:      opts.Importer,
+		verifyOpts:    opts.Verify,
+		copyInfo:      opts.CopyInfo,
+		provenance:    opts.Provenance,
+		positions:     opts.SyntheticPositions || opts.PreserveComments,
+
+		lowerShortCircuits:  opts.LowerShortCircuits,
+		splitTuples:         opts.SplitTuples,
+		lowerSwitches:       opts.LowerSwitches,
+		lowerTypeSwitches:   opts.LowerTypeSwitches,
+		lowerChanRanges:     opts.LowerChanRanges,
+		hoistSelectOperands: opts.HoistSelectOperands,
+		lowerRanges:         opts.LowerRanges,
+
+		packages:   make(map[string]*types.Package),
+		loopLabels: make(map[ast.Stmt]*ast.Ident),
+	}
+	if err := CheckTempPrefix(c.tempPrefix); err != nil {
+		panic(err)
+	}
+	if c.tempPrefix == "" {
+		c.tempPrefix = "_"
+	}
+	if opts.PreserveComments {
+		if opts.Fset == nil {
+			panic("PreserveComments requires Fset")
+		}
+		c.fset = opts.Fset
+	}
+	if c.positions && c.provenance == nil {
+		c.provenance = make(Provenance)
+	}
+	if c.pkg == nil {
+		for _, obj := range info.Defs {
+			if obj != nil && obj.Pkg() != nil {
+				c.pkg = obj.Pkg()
+				break
+			}
+		}
+	}
+	for _, initializer := range info.InitOrder {
+		c.initializers[initializer.Rhs] = initializer
+	}
+	return c
+}
+
+// CheckTempPrefix returns an error if the names of temporary variables with the
+// given prefix are not valid identifiers, see Options.TempPrefix.
+func CheckTempPrefix(prefix string) error {
+	if prefix != "" && !token.IsIdentifier(prefix+"1") {
+		return fmt.Errorf("the TempPrefix %q does not give valid identifiers", prefix)
+	}
+	return nil
+}
+
+// Simplify returns a simplified copy of file according to opts. A nil opts is
+// equivalent to the zero Options. The type information in info is updated to
+// cover the new nodes. Simplify panics if opts.CopyInfo is set, use
+// SimplifyPackage to get a copy of info instead.
+func Simplify(file *ast.File, info *types.Info, opts *Options) *ast.File {
+	if opts != nil && opts.CopyInfo {
+		panic("CopyInfo requires SimplifyPackage")
+	}
+	return newSimplifyContext(nil, info, opts).simplifyFile(file)
+}
+
+// SimplifyPackage simplifies all files of the type-checked package pkg. The
+// returned files replace the given ones and info is updated to describe them,
+// including the right-hand sides of info.InitOrder. If opts.CopyInfo is set, a
+// copy of info describes them instead and info stays unchanged.
+func SimplifyPackage(files []*ast.File, pkg *types.Package, info *types.Info, opts *Options) ([]*ast.File, *types.Info) {
+	c := newSimplifyContext(pkg, info, opts)
+	newFiles := make([]*ast.File, len(files))
+	for i, file := range files {
+		newFiles[i] = c.simplifyFile(file)
+	}
+	c.verify(newFiles)
+	return newFiles, c.info
+}
+
+func (c *simplifyContext) simplifyFile(file *ast.File) *ast.File {
+	defer c.enterScope(file)()
+	c.fileScope = c.info.Scopes[file]
+	c.collectImports(file)
+	c.collectOriginals(file)
+	c.perIteration = true
+	v := c.info.FileVersions[file]
+	if v == "" && c.pkg != nil {
+		v = c.pkg.GoVersion()
+	}
+	if v != "" && version.Compare(v, "go1.22") < 0 {
+		c.perIteration = false
+	}
 
 	decls := make([]ast.Decl, len(file.Decls))
 	for i, decl := range file.Decls {
 		c.varCounter = 0
-		switch decl := decl.(type) {
-		case *ast.GenDecl:
-			decls[i] = c.simplifyGenDecl(nil, decl)
-
-		case *ast.FuncDecl:
-			decls[i] = &ast.FuncDecl{
-				Doc:  decl.Doc,
-				Recv: decl.Recv,
-				Name: decl.Name,
-				Type: decl.Type,
-				Body: c.simplifyBlock(decl.Body),
-			}
+		if c.diagnostics != nil {
+			decls[i] = c.simplifyDeclOrReport(decl)
+		} else {
+			decls[i] = c.simplifyDecl(decl)
 		}
+		c.setOrigin(decl, decls[i])
 	}
 
+	decls, imports := c.addImports(file, decls)
 	newFile := &ast.File{
 		Doc:        file.Doc,
 		Package:    file.Package,
 		Name:       file.Name,
 		Decls:      decls,
 		Scope:      file.Scope,
-		Imports:    file.Imports,
+		Imports:    imports,
 		Unresolved: file.Unresolved,
 		Comments:   file.Comments,
 	}
 	c.info.Scopes[newFile] = c.info.Scopes[file]
+	c.setOrigin(file, newFile)
+	if c.positions {
+		c.setPositions(newFile, file)
+	}
+	if version, ok := c.info.FileVersions[file]; ok {
+		c.info.FileVersions[newFile] = version
+	}
 	return newFile
 }
 
@@ -57,6 +318,49 @@ func (c *simplifyContext) simplifyStmtList(stmts []ast.Stmt) []ast.Stmt {
 	return newStmts
 }
 
+// simplifyClauseBody simplifies the body of a case or comm clause within the scope of the clause.
+func (c *simplifyContext) simplifyClauseBody(clause ast.Node, body []ast.Stmt) []ast.Stmt {
+	defer c.enterScope(clause)()
+	return c.simplifyStmtList(body)
+}
+
+// enterScope makes the scope of node the current scope if it has one. The
+// returned function restores the previous scope.
+func (c *simplifyContext) enterScope(node ast.Node) func() {
+	outer := c.scope
+	if scope := c.info.Scopes[node]; scope != nil {
+		c.scope = scope
+	}
+	return func() {
+		c.scope = outer
+	}
+}
+
+func (c *simplifyContext) simplifyDecl(decl ast.Decl) ast.Decl {
+	switch decl := decl.(type) {
+	case *ast.GenDecl:
+		return c.simplifyGenDecl(nil, decl)
+
+	case *ast.FuncDecl:
+		defer c.enterScope(decl.Type)()
+		c.funcSig = nil
+		if obj := c.info.Defs[decl.Name]; obj != nil {
+			c.funcSig = obj.Type().(*types.Signature)
+		}
+		c.funcBody = decl.Body
+		return &ast.FuncDecl{
+			Doc:  decl.Doc,
+			Recv: decl.Recv,
+			Name: decl.Name,
+			Type: decl.Type,
+			Body: c.simplifyBlock(decl.Body),
+		}
+
+	default:
+		return decl
+	}
+}
+
 func (c *simplifyContext) simplifyGenDecl(stmts *[]ast.Stmt, decl *ast.GenDecl) *ast.GenDecl {
 	if decl.Tok != token.VAR {
 		return decl
@@ -67,16 +371,19 @@ func (c *simplifyContext) simplifyGenDecl(stmts *[]ast.Stmt, decl *ast.GenDecl)
 		switch spec := spec.(type) {
 		case *ast.ValueSpec:
 			var values []ast.Expr
-			if spec.Values != nil {
+			if stmts == nil && spec.Values != nil {
 				values = make([]ast.Expr, len(spec.Values))
 				for i, v := range spec.Values {
-					v2 := c.simplifyExpr(stmts, v)
-					for _, initializer := range c.info.InitOrder {
-						if initializer.Rhs == v {
-							initializer.Rhs = v2
-						}
+					values[i] = c.simplifyInitializer(v, spec.Type)
+				}
+			} else {
+				values = c.simplifyArgs(stmts, spec.Values)
+			}
+			if len(values) == len(spec.Values) {
+				for i, v := range spec.Values {
+					if initializer, ok := c.initializers[v]; ok {
+						initializer.Rhs = values[i]
 					}
-					values[i] = v2
 				}
 			}
 			specs[j] = &ast.ValueSpec{
@@ -105,6 +412,10 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 	if s == nil {
 		return
 	}
+	defer c.trackOrigin(stmts, s)()
+	if c.diagnostics != nil {
+		defer locatePanic(s.Pos())
+	}
 
 	switch s := s.(type) {
 	case *ast.ExprStmt:
@@ -116,7 +427,22 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 		*stmts = append(*stmts, c.simplifyBlock(s))
 
 	case *ast.LabeledStmt:
+		n := len(*stmts)
+		c.loopLabels[s.Stmt] = s.Label
 		c.simplifyStmt(stmts, s.Stmt)
+		if _, ok := c.loopLabels[s.Stmt]; !ok {
+			// The statement was lowered to code that handles the branches to the label
+			// by itself. The label only needs to stay for goto statements.
+			if usesLabel(c.funcBody, s.Label.Name) {
+				(*stmts)[n] = &ast.LabeledStmt{
+					Label: s.Label,
+					Colon: s.Colon,
+					Stmt:  (*stmts)[n],
+				}
+			}
+			return
+		}
+		delete(c.loopLabels, s.Stmt)
 		(*stmts)[len(*stmts)-1] = &ast.LabeledStmt{
 			Label: s.Label,
 			Colon: s.Colon,
@@ -124,13 +450,17 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 		}
 
 	case *ast.AssignStmt:
+		// The calls in the operands on the left and in the expressions on the right
+		// are evaluated first, from left to right. A call on the right may only
+		// stay in the assignment if no later expression gets moved in front of it.
+		// The assignments themselves are carried out by a single statement.
 		lhs := make([]ast.Expr, len(s.Lhs))
 		for i, x := range s.Lhs {
 			lhs[i] = c.simplifyExpr(stmts, x)
 		}
 		rhs := make([]ast.Expr, len(s.Rhs))
 		for i, x := range s.Rhs {
-			rhs[i] = c.simplifyExpr2(stmts, x, true)
+			rhs[i] = c.simplifyExpr2(stmts, x, !c.anyHoists(s.Rhs[i+1:], true))
 		}
 		*stmts = append(*stmts, &ast.AssignStmt{
 			Lhs:    lhs,
@@ -146,6 +476,7 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 
 	case *ast.IfStmt:
 		if s.Init != nil {
+			defer c.enterScope(s)()
 			block := &ast.BlockStmt{}
 			*stmts = append(*stmts, block)
 			stmts = &block.List
@@ -157,14 +488,23 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 			Body: c.simplifyBlock(s.Body),
 			Else: c.toElseBranch(c.simplifyToStmtList(s.Else), c.info.Scopes[s.Else]),
 		}
-		c.info.Scopes[newS] = c.info.Scopes[s]
+		c.recordScope(newS, c.info.Scopes[s])
 		*stmts = append(*stmts, newS)
 
 	case *ast.SwitchStmt:
+		if c.lowerSwitches {
+			c.lowerSwitch(stmts, s)
+			return
+		}
 		c.simplifySwitch(stmts, s)
 
 	case *ast.TypeSwitchStmt:
+		if _, binds := s.Assign.(*ast.AssignStmt); c.lowerTypeSwitches && (!binds || c.info.Implicits != nil) {
+			c.lowerTypeSwitch(stmts, s)
+			return
+		}
 		if s.Init != nil {
+			defer c.enterScope(s)()
 			block := &ast.BlockStmt{}
 			*stmts = append(*stmts, block)
 			stmts = &block.List
@@ -207,10 +547,10 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 				Case:  cc.Case,
 				List:  cc.List,
 				Colon: cc.Colon,
-				Body:  c.simplifyStmtList(cc.Body),
+				Body:  c.simplifyClauseBody(cc, cc.Body),
 			}
 			if implicit, ok := c.info.Implicits[cc]; ok {
-				c.info.Implicits[newClause] = implicit
+				c.recordImplicit(newClause, implicit)
 			}
 			clauses[i] = newClause
 		}
@@ -221,115 +561,83 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 				List: clauses,
 			},
 		}
-		c.info.Scopes[newS] = c.info.Scopes[s]
+		c.recordScope(newS, c.info.Scopes[s])
 		*stmts = append(*stmts, newS)
 
 	case *ast.ForStmt:
-		newS := &ast.ForStmt{
-			For:  s.For,
-			Init: s.Init,
-			Cond: s.Cond,
-			Post: s.Post,
-			Body: c.simplifyBlock(s.Body),
+		var init ast.Stmt
+		if s.Init != nil {
+			initStmts := c.simplifyToStmtList(s.Init)
+			init = initStmts[len(initStmts)-1]
+			*stmts = append(*stmts, initStmts[:len(initStmts)-1]...)
 		}
-		c.info.Scopes[newS] = c.info.Scopes[s]
-		*stmts = append(*stmts, newS)
 
-	// case *ast.ForStmt:
-	// 	c.simplifyStmt(stmts, s.Init)
-	// 	var condStmts []ast.Stmt
-	// 	cond := c.newVar(&condStmts, s.Cond)
-	// 	bodyStmts := s.Body.List
-	// 	if len(condStmts) != 0 {
-	// 		bodyStmts = append(append(condStmts, &ast.IfStmt{
-	// 			Cond: &ast.UnaryExpr{
-	// 				Op: token.NOT,
-	// 				X:  cond,
-	// 			},
-	// 			Body: &ast.BlockStmt{
-	// 				List: []ast.Stmt{&ast.BranchStmt{
-	// 					Tok: token.BREAK,
-	// 				}},
-	// 			},
-	// 		}), bodyStmts...)
-	// 		cond = nil
-	// 	}
-	// 	*stmts = append(*stmts, &ast.ForStmt{
-	// 		For:  s.For,
-	// 		Cond: cond,
-	// 		Post: s.Post,
-	// 		Body: &ast.BlockStmt{
-	// 			List: bodyStmts,
-	// 		},
-	// 	})
+		leave := c.enterScope(s.Body)
+		var condStmts []ast.Stmt
+		var cond ast.Expr
+		if s.Cond != nil {
+			cond = c.simplifyExpr(&condStmts, s.Cond)
+		}
+		var postStmts []ast.Stmt
+		var post ast.Stmt
+		if s.Post != nil {
+			postStmts = c.simplifyToStmtList(s.Post)
+			post = postStmts[len(postStmts)-1]
+		}
+		leave()
+
+		var bodyPrefix []ast.Stmt
+		if len(postStmts) > 1 {
+			// The post statement can not hold multiple statements, so it gets moved to the
+			// start of the body and skipped on the first iteration. A "continue" still runs it.
+			first := c.newIdent(types.Typ[types.Bool], s.Post.Pos())
+			*stmts = append(*stmts, simpleAssign(first, token.DEFINE, c.boolConst(true)))
+			bodyPrefix = append(bodyPrefix, &ast.IfStmt{
+				Cond: c.negate(c.ref(first)),
+				Body: &ast.BlockStmt{List: postStmts},
+			})
+			post = simpleAssign(c.ref(first), token.ASSIGN, c.boolConst(false))
+		}
 
-	case *ast.RangeStmt:
-		var newS ast.Stmt
-		switch t := c.info.TypeOf(s.X).Underlying().(type) {
-		case *types.Chan:
-			key := s.Key
-			tok := s.Tok
-			if key == nil {
-				key = ast.NewIdent("_")
-				tok = token.DEFINE
-			}
-			okVar := c.newIdent(types.Typ[types.Bool])
-			if s.Tok == token.ASSIGN {
-				*stmts = append(*stmts, &ast.DeclStmt{
-					Decl: &ast.GenDecl{
-						Tok: token.VAR,
-						Specs: []ast.Spec{&ast.ValueSpec{
-							Names: []*ast.Ident{okVar},
-							Type:  ast.NewIdent("bool"),
-						}},
-					},
-				})
-			}
-			newS = &ast.ForStmt{
-				For: s.For,
+		if cond != nil && (len(condStmts) != 0 || len(bodyPrefix) != 0) {
+			bodyPrefix = append(append(bodyPrefix, condStmts...), &ast.IfStmt{
+				Cond: c.negate(cond),
 				Body: &ast.BlockStmt{
-					Lbrace: s.Body.Lbrace,
-					List: append([]ast.Stmt{
-						&ast.AssignStmt{
-							Lhs:    []ast.Expr{key, okVar},
-							TokPos: s.TokPos,
-							Tok:    tok,
-							Rhs: []ast.Expr{c.setType(&ast.UnaryExpr{
-								Op: token.ARROW,
-								X:  c.newVar(stmts, s.X),
-							}, types.NewTuple(
-								types.NewVar(token.NoPos, nil, "", t.Elem()),
-								types.NewVar(token.NoPos, nil, "", types.Typ[types.Bool]),
-							))},
-						},
-						&ast.IfStmt{
-							Cond: c.setType(&ast.UnaryExpr{
-								Op: token.NOT,
-								X:  okVar,
-							}, types.Typ[types.Bool]),
-							Body: &ast.BlockStmt{
-								List: []ast.Stmt{
-									&ast.BranchStmt{Tok: token.BREAK},
-								},
-							},
-						},
-					}, c.simplifyStmtList(s.Body.List)...),
-					Rbrace: s.Body.Rbrace,
+					List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}},
 				},
-			}
+			})
+			cond = nil
+		}
 
-		default:
-			newS = &ast.RangeStmt{
-				For:    s.For,
-				Key:    s.Key,
-				Value:  s.Value,
-				TokPos: s.TokPos,
-				Tok:    s.Tok,
-				X:      s.X,
-				Body:   c.simplifyBlock(s.Body),
-			}
+		body := c.simplifyBlock(s.Body)
+		body.List = append(bodyPrefix, body.List...)
+		newS := &ast.ForStmt{
+			For:  s.For,
+			Init: init,
+			Cond: cond,
+			Post: post,
+			Body: body,
+		}
+		c.recordScope(newS, c.info.Scopes[s])
+		*stmts = append(*stmts, newS)
+
+	case *ast.RangeStmt:
+		if t, ok := coreType(c.info.TypeOf(s.X)).(*types.Chan); ok && c.lowerChanRanges && c.lowerChanRange(stmts, s, t) {
+			return
+		}
+		if c.lowerRanges && c.lowerRange(stmts, s) {
+			return
 		}
-		c.info.Scopes[newS] = c.info.Scopes[s]
+		newS := &ast.RangeStmt{
+			For:    s.For,
+			Key:    s.Key,
+			Value:  s.Value,
+			TokPos: s.TokPos,
+			Tok:    s.Tok,
+			X:      c.simplifyExpr2(stmts, s.X, true),
+			Body:   c.simplifyBlock(s.Body),
+		}
+		c.recordScope(newS, c.info.Scopes[s])
 		*stmts = append(*stmts, newS)
 
 	case *ast.IncDecStmt:
@@ -358,11 +666,7 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 					panic("unexpected comm clause")
 				}
 				newComm = &ast.ExprStmt{
-					X: &ast.UnaryExpr{
-						Op:    token.ARROW,
-						OpPos: recv.OpPos,
-						X:     c.simplifyExpr(stmts, recv.X),
-					},
+					X: c.selectRecv(stmts, recv),
 				}
 			case *ast.AssignStmt:
 				recv := comm.Rhs[0].(*ast.UnaryExpr)
@@ -371,30 +675,41 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 				}
 				simplifyLhs := false
 				for _, x := range comm.Lhs {
-					if c.simplifyCalls && ContainsCall(x) {
+					if c.simplifyCalls && c.containsHoisted(x) {
 						simplifyLhs = true
 					}
 				}
 				lhs := comm.Lhs
 				tok := comm.Tok
 				if simplifyLhs {
-					for i, x := range lhs {
-						id := c.newIdent(c.info.TypeOf(x))
-						bodyPrefix = append(bodyPrefix, simpleAssign(c.simplifyExpr(&bodyPrefix, x), comm.Tok, id))
+					// receive into temporary variables and assign them once the case is selected
+					leave := c.enterScope(cc)
+					lhs = make([]ast.Expr, len(comm.Lhs))
+					values := make([]ast.Expr, len(comm.Lhs))
+					for i, x := range comm.Lhs {
+						id := c.newIdent(c.info.TypeOf(x), x.Pos())
 						lhs[i] = id
+						values[i] = c.ref(id)
 					}
+					c.simplifyStmt(&bodyPrefix, &ast.AssignStmt{
+						Lhs:    comm.Lhs,
+						Tok:    comm.Tok,
+						TokPos: comm.TokPos,
+						Rhs:    values,
+					})
 					tok = token.DEFINE
+					leave()
 				}
 				newComm = &ast.AssignStmt{
 					Lhs: lhs,
 					Tok: tok,
-					Rhs: []ast.Expr{c.simplifyExpr(stmts, recv)},
+					Rhs: []ast.Expr{c.selectRecv(stmts, recv)},
 				}
 			case *ast.SendStmt:
 				newComm = &ast.SendStmt{
-					Chan:  c.simplifyExpr(stmts, comm.Chan),
+					Chan:  c.selectOperand(stmts, comm.Chan),
 					Arrow: comm.Arrow,
-					Value: c.simplifyExpr(stmts, comm.Value),
+					Value: c.selectOperand(stmts, comm.Value),
 				}
 			case nil:
 				newComm = nil
@@ -405,9 +720,9 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 				Case:  cc.Case,
 				Comm:  newComm,
 				Colon: cc.Colon,
-				Body:  append(bodyPrefix, c.simplifyStmtList(cc.Body)...),
+				Body:  append(bodyPrefix, c.simplifyClauseBody(cc, cc.Body)...),
 			}
-			c.info.Scopes[newCC] = c.info.Scopes[cc]
+			c.recordScope(newCC, c.info.Scopes[cc])
 			clauses[i] = newCC
 		}
 		*stmts = append(*stmts, &ast.SelectStmt{
@@ -433,7 +748,7 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 	case *ast.ReturnStmt:
 		*stmts = append(*stmts, &ast.ReturnStmt{
 			Return:  s.Return,
-			Results: c.simplifyExprList(stmts, s.Results),
+			Results: c.simplifyArgs(stmts, s.Results),
 		})
 
 	default:
@@ -441,29 +756,122 @@ func (c *simplifyContext) simplifyStmt(stmts *[]ast.Stmt, s ast.Stmt) {
 	}
 }
 
+// selectRecv simplifies the receive operation of a case of a select statement.
+func (c *simplifyContext) selectRecv(stmts *[]ast.Stmt, recv *ast.UnaryExpr) ast.Expr {
+	x := &ast.UnaryExpr{
+		Op:    token.ARROW,
+		OpPos: recv.OpPos,
+		X:     c.selectOperand(stmts, recv.X),
+	}
+	if t, ok := c.info.Types[recv]; ok {
+		c.recordType(x, t)
+	}
+	return x
+}
+
+// selectOperand simplifies a channel or a value to send of a case of a select
+// statement. All of them get evaluated when entering the select statement, in
+// source order.
+func (c *simplifyContext) selectOperand(stmts *[]ast.Stmt, x ast.Expr) ast.Expr {
+	x2 := c.simplifyExpr(stmts, x)
+	if _, isIdent := x2.(*ast.Ident); isIdent || !c.hoistSelectOperands || c.info.Types[x].Value != nil {
+		return x2
+	}
+	return c.newVar(stmts, x2, x)
+}
+
 func (c *simplifyContext) simplifyBlock(s *ast.BlockStmt) *ast.BlockStmt {
 	if s == nil {
 		return nil
 	}
+	defer c.enterScope(s)()
 	newS := &ast.BlockStmt{
 		Lbrace: s.Lbrace,
 		List:   c.simplifyStmtList(s.List),
 		Rbrace: s.Rbrace,
 	}
-	c.info.Scopes[newS] = c.info.Scopes[s]
+	c.recordScope(newS, c.info.Scopes[s])
+	c.setOrigin(s, newS)
 	return newS
 }
 
+// simplifySwitch simplifies a switch statement that does not get lowered. The
+// case expressions stay in place, since they only get evaluated until one of
+// them matches.
 func (c *simplifyContext) simplifySwitch(stmts *[]ast.Stmt, s *ast.SwitchStmt) {
+	var init ast.Stmt
+	if s.Init != nil {
+		initStmts := c.simplifyToStmtList(s.Init)
+		init = initStmts[len(initStmts)-1]
+		*stmts = append(*stmts, initStmts[:len(initStmts)-1]...)
+	}
+
+	var tagStmts []ast.Stmt
+	leave := func() {}
+	if init != nil {
+		// the tag may refer to the variables declared by init
+		leave = c.enterScope(s)
+	}
+	tag := c.simplifyExpr(&tagStmts, s.Tag)
+	leave()
+
+	clauses := make([]ast.Stmt, len(s.Body.List))
+	for i, cc := range s.Body.List {
+		clause := cc.(*ast.CaseClause)
+		newClause := &ast.CaseClause{
+			Case:  clause.Case,
+			List:  clause.List,
+			Colon: clause.Colon,
+			Body:  c.simplifyClauseBody(clause, clause.Body),
+		}
+		c.recordScope(newClause, c.info.Scopes[clause])
+		clauses[i] = newClause
+	}
+	newS := &ast.SwitchStmt{
+		Switch: s.Switch,
+		Init:   init,
+		Tag:    tag,
+		Body: &ast.BlockStmt{
+			Lbrace: s.Body.Lbrace,
+			List:   clauses,
+			Rbrace: s.Body.Rbrace,
+		},
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	if init == nil || len(tagStmts) == 0 {
+		*stmts = append(*stmts, tagStmts...)
+		*stmts = append(*stmts, newS)
+		return
+	}
+
+	// The statements computing the tag need the variables declared by init, so
+	// all of them move into a switch statement with only a default clause. Unlike
+	// a block, it stays a valid target of labeled break statements.
+	newS.Init = nil
+	wrapClause := &ast.CaseClause{Body: append(append([]ast.Stmt{init}, tagStmts...), newS)}
+	wrapper := &ast.SwitchStmt{
+		Switch: s.Switch,
+		Body:   &ast.BlockStmt{List: []ast.Stmt{wrapClause}},
+	}
+	c.recordScope(wrapper, c.info.Scopes[s])
+	c.recordScope(wrapClause, c.info.Scopes[s])
+	*stmts = append(*stmts, wrapper)
+}
+
+// lowerSwitch turns a switch statement into a chain of if statements within a
+// switch statement that only has a default clause, so that break statements
+// keep working.
+func (c *simplifyContext) lowerSwitch(stmts *[]ast.Stmt, s *ast.SwitchStmt) {
 	wrapClause := &ast.CaseClause{}
 	newS := &ast.SwitchStmt{
 		Switch: s.Switch,
 		Body:   &ast.BlockStmt{List: []ast.Stmt{wrapClause}},
 	}
-	c.info.Scopes[newS] = c.info.Scopes[s]
-	c.info.Scopes[wrapClause] = c.info.Scopes[s]
+	c.recordScope(newS, c.info.Scopes[s])
+	c.recordScope(wrapClause, c.info.Scopes[s])
 	*stmts = append(*stmts, newS)
 	stmts = &wrapClause.Body
+	defer c.enterScope(s)()
 
 	c.simplifyStmt(stmts, s.Init)
 
@@ -474,15 +882,14 @@ func (c *simplifyContext) simplifySwitch(stmts *[]ast.Stmt, s *ast.SwitchStmt) {
 
 func (c *simplifyContext) makeTag(stmts *[]ast.Stmt, tag ast.Expr, needsTag bool) ast.Expr {
 	if tag == nil {
-		id := ast.NewIdent("true")
-		c.info.Types[id] = types.TypeAndValue{Type: types.Typ[types.Bool], Value: constant.MakeBool(true)}
-		return id
+		return c.boolConst(true)
 	}
+	simplifiedTag := c.simplifyExpr2(stmts, tag, true)
 	if !needsTag {
-		*stmts = append(*stmts, simpleAssign(ast.NewIdent("_"), token.ASSIGN, tag))
+		*stmts = append(*stmts, simpleAssign(c.blankIdent(), token.ASSIGN, simplifiedTag))
 		return nil
 	}
-	return c.newVar(stmts, tag)
+	return c.newVar(stmts, simplifiedTag, tag)
 }
 
 func (c *simplifyContext) simplifyCaseClauses(clauses []ast.Stmt) (nonDefaultClauses []*ast.CaseClause, defaultClause *ast.CaseClause) {
@@ -494,7 +901,8 @@ func (c *simplifyContext) simplifyCaseClauses(clauses []ast.Stmt) (nonDefaultCla
 			List:  clause.List,
 			Colon: clause.Colon,
 		}
-		c.info.Scopes[newClause] = c.info.Scopes[clause]
+		c.recordScope(newClause, c.info.Scopes[clause])
+		c.setOrigin(clause, newClause)
 
 		body := clause.Body
 		hasFallthrough := false
@@ -524,7 +932,9 @@ func (c *simplifyContext) simplifyCaseClauses(clauses []ast.Stmt) (nonDefaultCla
 func (c *simplifyContext) switchToIfElse(tag ast.Expr, nonDefaultClauses []*ast.CaseClause, defaultClause *ast.CaseClause) ast.Stmt {
 	if len(nonDefaultClauses) == 0 {
 		if defaultClause != nil {
-			return c.toElseBranch(c.simplifyStmtList(defaultClause.Body), c.info.Scopes[defaultClause])
+			s := c.toElseBranch(c.simplifyClauseBody(defaultClause, defaultClause.Body), c.info.Scopes[defaultClause])
+			c.setOrigin(defaultClause, s)
+			return s
 		}
 		return nil
 	}
@@ -533,33 +943,42 @@ func (c *simplifyContext) switchToIfElse(tag ast.Expr, nonDefaultClauses []*ast.
 	conds := make([]ast.Expr, len(clause.List))
 	for i, cond := range clause.List {
 		conds[i] = c.setType(&ast.BinaryExpr{
-			X:  tag,
+			X:  c.ref(tag.(*ast.Ident)),
 			Op: token.EQL,
 			Y:  c.setType(&ast.ParenExpr{X: cond}, c.info.TypeOf(cond)),
 		}, types.Typ[types.Bool])
+		c.setOrigin(cond, conds[i])
 	}
 
 	var stmts []ast.Stmt
+	done := c.trackOrigin(&stmts, clause)
+	cond := c.simplifyExpr(&stmts, c.disjunction(conds))
+	done()
 	ifStmt := &ast.IfStmt{
 		If:   clause.Case,
-		Cond: c.simplifyExpr(&stmts, c.disjunction(conds)),
-		Body: &ast.BlockStmt{List: c.simplifyStmtList(clause.Body)},
-		Else: c.switchToIfElse(tag, nonDefaultClauses[1:], defaultClause),
+		Cond: cond,
+		Body: &ast.BlockStmt{List: c.simplifyClauseBody(clause, clause.Body)},
 	}
-	c.info.Scopes[ifStmt] = c.info.Scopes[clause]
+	c.recordScope(ifStmt, c.info.Scopes[clause])
+	c.setOrigin(clause, ifStmt)
+	ifStmt.Else = c.switchToIfElse(tag, nonDefaultClauses[1:], defaultClause)
 	stmts = append(stmts, ifStmt)
-	return c.toElseBranch(stmts, c.info.Scopes[clause])
+	branch := c.toElseBranch(stmts, c.info.Scopes[clause])
+	c.setOrigin(clause, branch)
+	return branch
 }
 
 func (c *simplifyContext) disjunction(conds []ast.Expr) ast.Expr {
 	if len(conds) == 1 {
 		return conds[0]
 	}
-	return c.setType(&ast.BinaryExpr{
+	x := c.setType(&ast.BinaryExpr{
 		X:  conds[0],
 		Op: token.LOR,
 		Y:  c.disjunction(conds[1:]),
 	}, types.Typ[types.Bool])
+	c.setOrigin(conds[0], x)
+	return x
 }
 
 func (c *simplifyContext) simplifyToStmtList(s ast.Stmt) (stmts []ast.Stmt) {
@@ -574,14 +993,14 @@ func (c *simplifyContext) toElseBranch(stmts []ast.Stmt, scope *types.Scope) ast
 	if len(stmts) == 1 {
 		switch stmt := stmts[0].(type) {
 		case *ast.IfStmt, *ast.BlockStmt:
-			c.info.Scopes[stmt] = scope
+			c.recordScope(stmt, scope)
 			return stmt
 		}
 	}
 	block := &ast.BlockStmt{
 		List: stmts,
 	}
-	c.info.Scopes[block] = scope
+	c.recordScope(block, scope)
 	return block
 }
 
@@ -600,9 +1019,11 @@ func (c *simplifyContext) simplifyExpr(stmts *[]ast.Stmt, x ast.Expr) ast.Expr {
 }
 
 func (c *simplifyContext) simplifyExpr2(stmts *[]ast.Stmt, x ast.Expr, callOK bool) ast.Expr {
+	done := c.trackOrigin(stmts, x)
 	x2 := c.simplifyExpr3(stmts, x, callOK)
+	done(x2)
 	if t, ok := c.info.Types[x]; ok {
-		c.info.Types[x2] = t
+		c.recordType(x2, t)
 	}
 	return x2
 }
@@ -610,6 +1031,13 @@ func (c *simplifyContext) simplifyExpr2(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bool) ast.Expr {
 	switch x := x.(type) {
 	case *ast.FuncLit:
+		defer c.enterScope(x.Type)()
+		outerSig, outerBody := c.funcSig, c.funcBody
+		defer func() {
+			c.funcSig, c.funcBody = outerSig, outerBody
+		}()
+		c.funcSig, _ = c.info.TypeOf(x).(*types.Signature)
+		c.funcBody = x.Body
 		return &ast.FuncLit{
 			Type: x.Type,
 			Body: &ast.BlockStmt{
@@ -650,7 +1078,7 @@ func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 			Sel: x.Sel,
 		}
 		if sel, ok := c.info.Selections[x]; ok {
-			c.info.Selections[selExpr] = sel
+			c.recordSelection(selExpr, sel)
 		}
 		return selExpr
 
@@ -662,6 +1090,14 @@ func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 			Rbrack: x.Rbrack,
 		}
 
+	case *ast.IndexListExpr:
+		return &ast.IndexListExpr{
+			X:       c.simplifyExpr(stmts, x.X),
+			Lbrack:  x.Lbrack,
+			Indices: x.Indices,
+			Rbrack:  x.Rbrack,
+		}
+
 	case *ast.SliceExpr:
 		return &ast.SliceExpr{
 			X:      c.simplifyExpr(stmts, x.X),
@@ -686,7 +1122,7 @@ func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 		if callOK || !c.simplifyCalls {
 			return call
 		}
-		return c.newVar(stmts, call)
+		return c.newVar(stmts, call, x)
 
 	case *ast.StarExpr:
 		return &ast.StarExpr{
@@ -695,16 +1131,34 @@ func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 		}
 
 	case *ast.UnaryExpr:
-		return &ast.UnaryExpr{
+		unary := &ast.UnaryExpr{
 			OpPos: x.OpPos,
 			Op:    x.Op,
 			X:     c.simplifyExpr(stmts, x.X),
 		}
+		if x.Op != token.ARROW || !c.hoistReceives || callOK || !c.simplifyCalls {
+			return unary
+		}
+		return c.newVar(stmts, unary, x)
 
 	case *ast.BinaryExpr:
-		if (x.Op == token.LAND || x.Op == token.LOR) && c.simplifyCalls && ContainsCall(x.Y) {
-			v := c.newVar(stmts, x.X)
-			cond := v
+		if (x.Op == token.LAND || x.Op == token.LOR) && c.simplifyCalls && c.containsHoisted(x.Y) {
+			if !c.lowerShortCircuits {
+				// The calls in the right operand may not be evaluated, so they stay in
+				// place and the whole expression gets moved instead.
+				binary := &ast.BinaryExpr{
+					X:     c.simplifyExpr(stmts, x.X),
+					OpPos: x.OpPos,
+					Op:    x.Op,
+					Y:     x.Y,
+				}
+				if callOK {
+					return binary
+				}
+				return c.newVar(stmts, c.setType(binary, c.info.TypeOf(x)), x)
+			}
+			v := c.newVar(stmts, c.simplifyExpr2(stmts, x.X, true), x.X).(*ast.Ident)
+			cond := ast.Expr(c.ref(v))
 			if x.Op == token.LOR {
 				cond = &ast.UnaryExpr{
 					Op: token.NOT,
@@ -712,7 +1166,7 @@ func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 				}
 			}
 			var ifBody []ast.Stmt
-			ifBody = append(ifBody, simpleAssign(v, token.ASSIGN, c.simplifyExpr2(&ifBody, x.Y, true)))
+			ifBody = append(ifBody, simpleAssign(c.ref(v), token.ASSIGN, c.simplifyExpr2(&ifBody, x.Y, true)))
 			*stmts = append(*stmts, &ast.IfStmt{
 				Cond: cond,
 				Body: &ast.BlockStmt{
@@ -733,6 +1187,46 @@ func (c *simplifyContext) simplifyExpr3(stmts *[]ast.Stmt, x ast.Expr, callOK bo
 	}
 }
 
+// anyHoists reports whether simplifying any of exprs moves parts of it into
+// separate statements.
+func (c *simplifyContext) anyHoists(exprs []ast.Expr, callOK bool) bool {
+	if !c.simplifyCalls {
+		return false
+	}
+	for _, x := range exprs {
+		if call, ok := x.(*ast.CallExpr); ok && callOK {
+			if c.containsHoisted(call.Fun) {
+				return true
+			}
+			for _, arg := range call.Args {
+				if c.containsHoisted(arg) {
+					return true
+				}
+			}
+			continue
+		}
+		if recv, ok := x.(*ast.UnaryExpr); ok && recv.Op == token.ARROW && callOK {
+			if c.containsHoisted(recv.X) {
+				return true
+			}
+			continue
+		}
+		if c.containsHoisted(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsHoisted reports whether x contains an operation that SimplifyCalls
+// moves into a separate statement, see HoistReceives.
+func (c *simplifyContext) containsHoisted(x ast.Expr) bool {
+	if c.hoistReceives {
+		return ContainsBlockingOp(x)
+	}
+	return ContainsCall(x)
+}
+
 func (c *simplifyContext) simplifyCall(stmts *[]ast.Stmt, x *ast.CallExpr) *ast.CallExpr {
 	return &ast.CallExpr{
 		Fun:      c.simplifyExpr(stmts, x.Fun),
@@ -743,16 +1237,31 @@ func (c *simplifyContext) simplifyCall(stmts *[]ast.Stmt, x *ast.CallExpr) *ast.
 	}
 }
 
+// simplifyArgs simplifies the arguments of a call, the results of a return
+// statement or the values of a variable declaration. A single call that
+// provides all of them stays in place, unless SplitTuples is set, in which case
+// its results get stored in temporary variables of their own.
 func (c *simplifyContext) simplifyArgs(stmts *[]ast.Stmt, args []ast.Expr) []ast.Expr {
 	if len(args) == 1 {
-		if tuple, ok := c.info.TypeOf(args[0]).(*types.Tuple); ok && c.simplifyCalls {
+		if _, ok := c.info.TypeOf(args[0]).(*types.Tuple); ok && !isCall(args[0]) {
+			// a comma-ok expression, e.g. a receive operation, stays in place
+			return []ast.Expr{c.simplifyExpr2(stmts, args[0], true)}
+		}
+		if tuple, ok := c.tupleCall(args[0]); ok && c.simplifyCalls {
+			if !c.splitTuples {
+				// the call stays the only argument
+				return []ast.Expr{c.simplifyExpr2(stmts, args[0], true)}
+			}
 			call := c.simplifyExpr2(stmts, args[0], true)
+			lhs := make([]ast.Expr, tuple.Len())
 			vars := make([]ast.Expr, tuple.Len())
 			for i := range vars {
-				vars[i] = c.newIdent(tuple.At(i).Type())
+				id := c.newIdent(tuple.At(i).Type(), args[0].Pos())
+				lhs[i] = id
+				vars[i] = c.ref(id)
 			}
 			*stmts = append(*stmts, &ast.AssignStmt{
-				Lhs: vars,
+				Lhs: lhs,
 				Tok: token.DEFINE,
 				Rhs: []ast.Expr{call},
 			})
@@ -773,25 +1282,276 @@ func (c *simplifyContext) simplifyExprList(stmts *[]ast.Stmt, exprs []ast.Expr)
 	return simplifiedExprs
 }
 
-func (c *simplifyContext) newVar(stmts *[]ast.Stmt, x ast.Expr) ast.Expr {
-	id := c.newIdent(c.info.TypeOf(x))
+// simplifyInitializer simplifies the value v of a package-level variable
+// declaration with the type typ, which may be nil. There is no place for
+// temporary variables at package level, so the statements that v needs go
+// into a function literal that gets called instead:
+//
+//	var x = f()()
+//
+// becomes
+//
+//	var x = func() T { _1 := f(); return _1() }()
+//
+// The initialization order stays the same, since the call refers to the same
+// variables and functions as v.
+func (c *simplifyContext) simplifyInitializer(v ast.Expr, typ ast.Expr) ast.Expr {
+	if !c.anyHoists([]ast.Expr{v}, true) {
+		return c.simplifyExpr2(nil, v, true)
+	}
+	t := c.info.TypeOf(v)
+	results, ok := t.(*types.Tuple)
+	if !ok {
+		if typ != nil {
+			t = c.info.TypeOf(typ)
+		} else {
+			t = types.Default(t)
+		}
+		results = types.NewTuple(types.NewVar(v.Pos(), c.pkg, "", t))
+	}
+	if !c.canDenoteTuple(results) {
+		// leave v alone, its calls still get evaluated in order
+		if c.diagnostics != nil {
+			*c.diagnostics = append(*c.diagnostics, Diagnostic{
+				Pos:     v.Pos(),
+				Message: fmt.Sprintf("cannot simplify: the type %s of the value can not be denoted", t),
+			})
+		}
+		return v
+	}
+
+	funcType := &ast.FuncType{
+		Params:  &ast.FieldList{},
+		Results: c.fieldList(results, false, v.Pos()),
+	}
+	c.newScope(funcType, c.scope, v.Pos(), v.End(), "function")
+	leave := c.enterScope(funcType)
+	var body []ast.Stmt
+	x := c.simplifyExpr2(&body, v, true)
+	leave()
+	body = append(body, &ast.ReturnStmt{Results: []ast.Expr{x}})
+
+	lit := c.setType(&ast.FuncLit{
+		Type: funcType,
+		Body: &ast.BlockStmt{List: body},
+	}, types.NewSignatureType(nil, nil, nil, types.NewTuple(), results, false))
+	call := c.setType(&ast.CallExpr{Fun: lit}, t)
+	c.setOrigin(v, call)
+	return call
+}
+
+// tupleCall returns the result types of x if it is a call with multiple
+// results. Comma-ok expressions do not count.
+func (c *simplifyContext) tupleCall(x ast.Expr) (*types.Tuple, bool) {
+	if !isCall(x) {
+		return nil, false
+	}
+	tuple, ok := c.info.TypeOf(x).(*types.Tuple)
+	return tuple, ok
+}
+
+func isCall(x ast.Expr) bool {
+	_, ok := x.(*ast.CallExpr)
+	return ok
+}
+
+// newVar stores x in a new temporary variable and returns a reference to it.
+// The expression x is the simplified form of orig.
+func (c *simplifyContext) newVar(stmts *[]ast.Stmt, x, orig ast.Expr) ast.Expr {
+	id := c.newIdent(types.Default(c.info.TypeOf(orig)), orig.Pos())
 	*stmts = append(*stmts, simpleAssign(id, token.DEFINE, x))
+	return c.ref(id)
+}
+
+// newIdent declares a new temporary variable of type t in the current scope and
+// returns the identifier of its declaration. Further references to the
+// variable must be created with ref.
+func (c *simplifyContext) newIdent(t types.Type, pos token.Pos) *ast.Ident {
+	id := ast.NewIdent(c.freshName())
+	obj := types.NewVar(pos, c.pkg, id.Name, t)
+	if c.scope != nil {
+		c.insert(c.scope, obj)
+	}
+	c.recordDef(id, obj)
+	return id
+}
+
+// newBlankIdent returns a blank identifier that declares a variable of type t,
+// as used on the left-hand side of a short variable declaration.
+func (c *simplifyContext) newBlankIdent(t types.Type, pos token.Pos) *ast.Ident {
+	id := ast.NewIdent("_")
+	c.recordDef(id, types.NewVar(pos, c.pkg, id.Name, t))
+	return id
+}
+
+// blankIdent returns a blank identifier for the left-hand side of an assignment.
+func (c *simplifyContext) blankIdent() *ast.Ident {
+	id := ast.NewIdent("_")
+	c.recordDef(id, nil)
 	return id
 }
 
-func (c *simplifyContext) newIdent(t types.Type) *ast.Ident {
-	c.varCounter++
-	id := ast.NewIdent(fmt.Sprintf("_%d", c.varCounter))
-	c.info.Types[id] = types.TypeAndValue{Type: t} // TODO remove?
-	c.info.Uses[id] = types.NewVar(token.NoPos, nil, id.Name, t)
+func (c *simplifyContext) universeIdent(name string) *ast.Ident {
+	id := ast.NewIdent(name)
+	c.recordUse(id, types.Universe.Lookup(name))
+	return id
+}
+
+// ref returns a new identifier that refers to the same object as id.
+func (c *simplifyContext) ref(id *ast.Ident) *ast.Ident {
+	newID := ast.NewIdent(id.Name)
+	obj := c.info.Defs[id]
+	if obj == nil {
+		obj = c.info.Uses[id]
+	}
+	if obj != nil {
+		c.recordUse(newID, obj)
+	}
+	if tv, ok := c.info.Types[id]; ok {
+		c.recordType(newID, tv)
+	} else if obj != nil {
+		c.recordType(newID, types.TypeAndValue{Type: obj.Type()})
+	}
+	return newID
+}
+
+// freshName returns a name for a temporary variable that is neither visible in
+// the current scope nor declared in any scope nested within it, so it can
+// neither be shadowed nor shadow a declaration.
+func (c *simplifyContext) freshName() string {
+	for {
+		c.varCounter++
+		name := fmt.Sprintf("%s%d", c.tempPrefix, c.varCounter)
+		if c.scope == nil || !c.isDeclared(c.scope, name) {
+			return name
+		}
+	}
+}
+
+func (c *simplifyContext) isDeclared(scope *types.Scope, name string) bool {
+	if c.lookupParent(scope, name) != nil {
+		return true
+	}
+	return isDeclaredInChildren(scope, name)
+}
+
+func isDeclaredInChildren(scope *types.Scope, name string) bool {
+	for i := 0; i < scope.NumChildren(); i++ {
+		child := scope.Child(i)
+		if child.Lookup(name) != nil || isDeclaredInChildren(child, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *simplifyContext) intConst(value int64) ast.Expr {
+	x := &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(value, 10)}
+	c.recordType(x, types.TypeAndValue{Type: types.Typ[types.Int], Value: constant.MakeInt64(value)})
+	return x
+}
+
+// builtinCall returns a call of the predeclared function name, which returns t.
+func (c *simplifyContext) builtinCall(name string, t types.Type, args ...ast.Expr) ast.Expr {
+	return c.setType(&ast.CallExpr{Fun: c.universeIdent(name), Args: args}, t)
+}
+
+// methodCall returns a call of the exported method name of x, which takes no
+// arguments and returns a single result.
+func (c *simplifyContext) methodCall(x ast.Expr, name string) ast.Expr {
+	sel := types.NewMethodSet(c.info.TypeOf(x)).Lookup(nil, name)
+	fun := &ast.SelectorExpr{X: x, Sel: ast.NewIdent(name)}
+	c.recordUse(fun.Sel, sel.Obj())
+	if c.info.Selections != nil {
+		c.recordSelection(fun, sel)
+	}
+	c.setType(fun, sel.Type())
+	return c.setType(&ast.CallExpr{Fun: fun}, sel.Type().(*types.Signature).Results().At(0).Type())
+}
+
+func (c *simplifyContext) boolConst(value bool) ast.Expr {
+	id := c.universeIdent(fmt.Sprintf("%t", value))
+	c.recordType(id, types.TypeAndValue{Type: types.Typ[types.Bool], Value: constant.MakeBool(value)})
 	return id
 }
 
+func (c *simplifyContext) negate(x ast.Expr) ast.Expr {
+	t := c.info.TypeOf(x)
+	switch x.(type) {
+	case *ast.Ident, *ast.ParenExpr, *ast.CallExpr, *ast.SelectorExpr, *ast.IndexExpr:
+	default:
+		x = c.setType(&ast.ParenExpr{X: x}, t)
+	}
+	return c.setType(&ast.UnaryExpr{
+		Op: token.NOT,
+		X:  x,
+	}, t)
+}
+
 func (c *simplifyContext) setType(x ast.Expr, t types.Type) ast.Expr {
-	c.info.Types[x] = types.TypeAndValue{Type: t}
+	c.recordType(x, types.TypeAndValue{Type: t})
 	return x
 }
 
+// coreType returns the underlying type of t. For a type parameter it returns the
+// single underlying type shared by all types in its type set, or nil if there is none.
+func coreType(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	if tp, ok := t.(*types.TypeParam); ok {
+		return interfaceCoreType(tp.Constraint().Underlying().(*types.Interface))
+	}
+	return t.Underlying()
+}
+
+func interfaceCoreType(iface *types.Interface) types.Type {
+	var core types.Type
+	for i := 0; i < iface.NumEmbeddeds(); i++ {
+		var terms []types.Type
+		switch e := iface.EmbeddedType(i).(type) {
+		case *types.Union:
+			for j := 0; j < e.Len(); j++ {
+				terms = append(terms, e.Term(j).Type())
+			}
+		default:
+			terms = append(terms, e)
+		}
+		for _, term := range terms {
+			u := coreType(term)
+			if embedded, ok := u.(*types.Interface); ok {
+				if embedded.IsMethodSet() {
+					continue
+				}
+				u = interfaceCoreType(embedded)
+			}
+			if u == nil {
+				return nil
+			}
+			if core == nil {
+				core = u
+				continue
+			}
+			if coreChan, ok := core.(*types.Chan); ok {
+				// channels with identical element types may differ in direction
+				if ch, ok := u.(*types.Chan); ok && types.Identical(coreChan.Elem(), ch.Elem()) {
+					switch {
+					case coreChan.Dir() == ch.Dir() || ch.Dir() == types.SendRecv:
+						continue
+					case coreChan.Dir() == types.SendRecv:
+						core = ch
+						continue
+					}
+				}
+			}
+			if !types.Identical(core, u) {
+				return nil
+			}
+		}
+	}
+	return core
+}
+
 func simpleAssign(lhs ast.Expr, tok token.Token, rhs ast.Expr) *ast.AssignStmt {
 	return &ast.AssignStmt{
 		Lhs: []ast.Expr{lhs},
@@ -800,35 +1560,59 @@ func simpleAssign(lhs ast.Expr, tok token.Token, rhs ast.Expr) *ast.AssignStmt {
 	}
 }
 
+// ContainsCall reports whether x contains a function call outside of function
+// literals.
 func ContainsCall(x ast.Expr) bool {
+	return containsOp(x, false)
+}
+
+// ContainsBlockingOp reports whether x contains a function call or a receive
+// operation outside of function literals, i.e. an operation that may block the
+// goroutine. These are the operations that SimplifyCalls moves into separate
+// statements if HoistReceives is set.
+func ContainsBlockingOp(x ast.Expr) bool {
+	return containsOp(x, true)
+}
+
+func containsOp(x ast.Expr, recv bool) bool {
 	switch x := x.(type) {
 	case *ast.CallExpr:
 		return true
 	case *ast.CompositeLit:
 		for _, elt := range x.Elts {
-			if ContainsCall(elt) {
+			if containsOp(elt, recv) {
 				return true
 			}
 		}
 		return false
 	case *ast.KeyValueExpr:
-		return ContainsCall(x.Key) || ContainsCall(x.Value)
+		return containsOp(x.Key, recv) || containsOp(x.Value, recv)
 	case *ast.ParenExpr:
-		return ContainsCall(x.X)
+		return containsOp(x.X, recv)
 	case *ast.SelectorExpr:
-		return ContainsCall(x.X)
+		return containsOp(x.X, recv)
 	case *ast.IndexExpr:
-		return ContainsCall(x.X) || ContainsCall(x.Index)
+		return containsOp(x.X, recv) || containsOp(x.Index, recv)
+	case *ast.IndexListExpr:
+		if containsOp(x.X, recv) {
+			return true
+		}
+		for _, index := range x.Indices {
+			if containsOp(index, recv) {
+				return true
+			}
+		}
+		return false
 	case *ast.SliceExpr:
-		return ContainsCall(x.X) || ContainsCall(x.Low) || ContainsCall(x.High) || ContainsCall(x.Max)
+		return containsOp(x.X, recv) || containsOp(x.Low, recv) || containsOp(x.High, recv) || containsOp(x.Max, recv)
 	case *ast.TypeAssertExpr:
-		return ContainsCall(x.X)
+		return containsOp(x.X, recv)
 	case *ast.StarExpr:
-		return ContainsCall(x.X)
+		return containsOp(x.X, recv)
 	case *ast.UnaryExpr:
-		return ContainsCall(x.X)
+		return recv && x.Op == token.ARROW || containsOp(x.X, recv)
 	case *ast.BinaryExpr:
-		return ContainsCall(x.X) || ContainsCall(x.Y)
+		return containsOp(x.X, recv) || containsOp(x.Y, recv)
 	default:
 		return false
 	}
@@ -10,6 +10,8 @@ import (
 	"go/token"
 	"go/types"
 	"io/ioutil"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -29,6 +31,9 @@ func TestSimplify(t *testing.T) {
 	simplifyAndCompareStmts(t, "T{a(), b()}", "_1 := a(); _2 := b(); T{_1, _2}")
 	simplifyAndCompareStmts(t, "T{A: a(), B: b()}", "_1 := a(); _2 := b(); T{A: _1, B: _2}")
 	simplifyAndCompareStmts(t, "func() { a()() }", "func() { _1 := a(); _1() }")
+	simplifyAndCompareStmts(t, "f[int](g())", "_1 := g(); f[int](_1)")
+	simplifyAndCompareStmts(t, "f[int, string](g())", "_1 := g(); f[int, string](_1)")
+	simplifyAndCompareStmts(t, "f()[int, string]", "_1 := f(); _1[int, string]")
 
 	simplifyAndCompareStmts(t, "a() && b", "_1 := a(); _1 && b")
 	simplifyAndCompareStmts(t, "a && b()", "_1 := a; if _1 { _1 = b() }; _1")
@@ -37,11 +42,18 @@ func TestSimplify(t *testing.T) {
 	simplifyAndCompareStmts(t, "a() || b", "_1 := a(); _1 || b")
 	simplifyAndCompareStmts(t, "a || b()", "_1 := a; if !_1 { _1 = b() }; _1")
 	simplifyAndCompareStmts(t, "a() || b()", "_1 := a(); if !_1 { _1 = b() }; _1")
+	simplifyAndCompareStmts(t, "a()() || b()", "_1 := a(); _2 := _1(); if !_2 { _2 = b() }; _2")
 
 	simplifyAndCompareStmts(t, "a && (b || c())", "_1 := a; if(_1) { _2 := b; if(!_2) { _2 = c() }; _1 = (_2) }; _1")
 
 	simplifyAndCompareStmts(t, "a := b()()", "_1 := b(); a := _1()")
 	simplifyAndCompareStmts(t, "a().f = b", "_1 := a(); _1.f = b")
+	simplifyAndCompareStmts(t, "a, b = c(), d()", "a, b = c(), d()")
+	simplifyAndCompareStmts(t, "a, b = c()(), d()", "_1 := c(); a, b = _1(), d()")
+	simplifyAndCompareStmts(t, "a, b = c(), d()()", "_1 := c(); _2 := d(); a, b = _1, _2()")
+	simplifyAndCompareStmts(t, "a[b()], c[d()] = e(), f()", "_1 := b(); _2 := d(); a[_1], c[_2] = e(), f()")
+	simplifyAndCompareStmts(t, "a[b()], c = d(), e() + f", "_1 := b(); _2 := d(); _3 := e(); a[_1], c = _2, _3 + f")
+	simplifyAndCompareStmts(t, "*a() += b()()", "_1 := a(); _2 := b(); *_1 += _2()")
 	simplifyAndCompareStmts(t, "var a int = b()", "_1 := b(); var a int = _1")
 
 	simplifyAndCompareStmts(t, "if a() { b }", "_1 := a(); if _1 { b }")
@@ -59,6 +71,8 @@ func TestSimplify(t *testing.T) {
 	simplifyAndCompareStmts(t, "switch a { case b(): c }", "switch { default: _1 := a; _2 := b(); if _1 == (_2) { c } }")
 	simplifyAndCompareStmts(t, "switch a { default: d; fallthrough; case b: c }", "switch { default: _1 := a; if _1 == (b) { c } else { d; c } }")
 	simplifyAndCompareStmts(t, "switch a := 0; a {}", "switch { default: a := 0; _ = a }")
+	simplifyAndCompareStmts(t, "switch a()() {}", "switch { default: _1 := a(); _ = _1() }")
+	simplifyAndCompareStmts(t, "switch a()() { case b: c }", "switch { default: _1 := a(); _2 := _1(); if _2 == (b) { c } }")
 	simplifyAndCompareStmts(t, "switch a := 0; a { default: }", "switch { default: a := 0; _ = a }")
 
 	simplifyAndCompareStmts(t, "switch a().(type) { case b, c: d }", "_1 := a(); switch _1.(type) { case b, c: d }")
@@ -67,11 +81,19 @@ func TestSimplify(t *testing.T) {
 	simplifyAndCompareStmts(t, "switch a.(type) { case b, c: d()() }", "switch a.(type) { case b, c: _1 := d(); _1() }")
 
 	simplifyAndCompareStmts(t, "for a { b()() }", "for a { _1 := b(); _1() }")
-	// simplifyAndCompareStmts(t, "for a() { b() }", "for { _1 := a(); if !_1 { break }; b() }")
+	simplifyAndCompareStmts(t, "for a() { b() }", "for { _1 := a(); if !_1 { break }; b() }")
+	simplifyAndCompareStmts(t, "for a && b() { c }", "for { _1 := a; if _1 { _1 = b() }; if !_1 { break }; c }")
+	simplifyAndCompareStmts(t, "for i := 0; i < a(); i++ { b }", "for i := 0; ; i++ { _1 := a(); if !(i < _1) { break }; b }")
+	simplifyAndCompareStmts(t, "for i := a()(); i < b; i++ { c }", "_1 := a(); for i := _1(); i < b; i++ { c }")
+	simplifyAndCompareStmts(t, "for ; a; b()() { c }", "_2 := true; for ; ; _2 = false { if !_2 { _1 := b(); _1() }; if !a { break }; c }")
+	simplifyAndCompareStmts(t, "for a()() { continue }", "for { _1 := a(); _2 := _1(); if !_2 { break }; continue }")
+	simplifyAndCompareStmts(t, "for { if a { continue }; b }", "for { if a { continue }; b }")
+	simplifyAndCompareStmts(t, "for ; ; a()() { if b { continue }; c }", "_2 := true; for ; ; _2 = false { if !_2 { _1 := a(); _1() }; if b { continue }; c }")
+	simplifyAndCompareStmts(t, "l: for ; a(); b()() { for { continue l } }", "_3 := true; l: for ; ; _3 = false { if !_3 { _2 := b(); _2() }; _1 := a(); if !_1 { break }; for { continue l } }")
 
 	simplifyAndCompareStmts(t, "select { case <-a: b()(); default: c()() }", "select { case <-a: _1 := b(); _1(); default: _2 := c(); _2() }")
 	simplifyAndCompareStmts(t, "select { case <-a(): b; case <-c(): d }", "_1 := a(); _2 := c(); select { case <-_1: b; case <-_2: d }")
-	simplifyAndCompareStmts(t, "var d int; select { case a().f, a().g = <-b(): c; case d = <-e(): f }", "var d int; _5 := b(); _6 := e(); select { case _1, _3 := <-_5: _2 := a(); _2.f = _1; _4 := a(); _4.g = _3; c; case d = <-_6: f }")
+	simplifyAndCompareStmts(t, "var d int; select { case a().f, a().g = <-b(): c; case d = <-e(): f }", "var d int; _5 := b(); _6 := e(); select { case _1, _2 := <-_5: _3 := a(); _4 := a(); _3.f, _4.g = _1, _2; c; case d = <-_6: f }")
 	simplifyAndCompareStmts(t, "select { case a() <- b(): c; case d() <- e(): f }", "_1 := a(); _2 := b(); _3 := d(); _4 := e(); select { case _1 <- _2: c; case _3 <- _4: f }")
 
 	simplifyAndCompareStmts(t, "a().f++", "_1 := a(); _1.f++")
@@ -81,27 +103,35 @@ func TestSimplify(t *testing.T) {
 	simplifyAndCompareStmts(t, "a() <- b", "_1 := a(); _1 <- b")
 	simplifyAndCompareStmts(t, "a <- b()", "_1 := b(); a <- _1")
 
-	for _, name := range []string{"var", "tuple", "range"} {
-		fset := token.NewFileSet()
-		inFile, err := parser.ParseFile(fset, fmt.Sprintf("testdata/%s.go", name), nil, 0)
-		if err != nil {
-			t.Fatal(err)
-		}
+	simplifyAndCompareStmtsWithOptions(t, allLowerings(Options{TempPrefix: "tmp"}), "a()()", "tmp1 := a(); tmp1()")
 
-		typesInfo := &types.Info{
-			Types:  make(map[ast.Expr]types.TypeAndValue),
-			Defs:   make(map[*ast.Ident]types.Object),
-			Uses:   make(map[*ast.Ident]types.Object),
-			Scopes: make(map[ast.Node]*types.Scope),
-		}
-		config := &types.Config{
-			Importer: importer.Default(),
-		}
-		if _, err := config.Check("main", fset, []*ast.File{inFile}, typesInfo); err != nil {
-			t.Fatal(err)
-		}
+	callsOnly := &Options{SimplifyCalls: true}
+	simplifyAndCompareStmtsWithOptions(t, callsOnly, "x := a() && b(c())", "_1 := a(); x := _1 && b(c())")
+	simplifyAndCompareStmtsWithOptions(t, callsOnly, "f(a || b())", "_1 := a || b(); f(_1)")
+	simplifyAndCompareStmtsWithOptions(t, callsOnly, "switch a() { case b(): c()() }", "_1 := a(); switch _1 { case b(): _2 := c(); _2() }")
+	simplifyAndCompareStmtsWithOptions(t, callsOnly, "switch x := a(); x { case b: c }", "switch x := a(); x { case b: c }")
+	simplifyAndCompareStmtsWithOptions(t, callsOnly, "l: switch x := a(); f(x) { case b: break l }", "l: switch { default: x := a(); _1 := f(x); switch _1 { case b: break l } }")
+	simplifyAndCompareStmtsWithOptions(t, callsOnly, "select { case a.b <- c[d]: }", "select { case a.b <- c[d]: }")
+	simplifyAndCompareStmtsWithOptions(t, &Options{LowerSwitches: true}, "switch a() { case b: c()() }", "switch { default: _1 := a(); if _1 == (b) { c()() } }")
+	simplifyAndCompareStmtsWithOptions(t, &Options{HoistSelectOperands: true}, "select { case a.b <- c[d]: e; case <-f: }", "_1 := a.b; _2 := c[d]; select { case _1 <- _2: e; case <-f: }")
+	simplifyAndCompareStmtsWithOptions(t, &Options{HoistSelectOperands: true}, "select { case x := <-a(): }", "_1 := a(); select { case x := <-_1: }")
 
-		outFile := Simplify(inFile, typesInfo, true)
+	receives := allLowerings(Options{HoistReceives: true})
+	simplifyAndCompareStmtsWithOptions(t, receives, "f(<-ch, g)", "_1 := <-ch; f(_1, g)")
+	simplifyAndCompareStmtsWithOptions(t, receives, "x := a && <-ch", "_1 := a; if _1 { _1 = <-ch }; x := _1")
+	simplifyAndCompareStmtsWithOptions(t, receives, "x := <-ch || a", "_1 := <-ch; x := _1 || a")
+	simplifyAndCompareStmtsWithOptions(t, receives, "x := <-<-ch", "_1 := <-ch; x := <-_1")
+	simplifyAndCompareStmtsWithOptions(t, receives, "v, ok := <-ch", "v, ok := <-ch")
+	simplifyAndCompareStmtsWithOptions(t, receives, "a, b = <-ch, f()", "a, b = <-ch, f()")
+	simplifyAndCompareStmtsWithOptions(t, receives, "a, b = <-ch, f()()", "_1 := <-ch; _2 := f(); a, b = _1, _2()")
+	simplifyAndCompareStmtsWithOptions(t, receives, "<-ch", "<-ch")
+	simplifyAndCompareStmtsWithOptions(t, &Options{SimplifyCalls: true, HoistReceives: true}, "x := a && <-ch", "x := a && <-ch")
+	simplifyAndCompareStmtsWithOptions(t, &Options{SimplifyCalls: true, HoistReceives: true}, "f(a && <-ch)", "_1 := a && <-ch; f(_1)")
+	simplifyAndCompareStmts(t, "f(<-ch, g)", "f(<-ch, g)")
+	simplifyAndCompareStmts(t, "x := a && <-ch", "x := a && <-ch")
+
+	forEachTestFile(t, func(name string, opts Options, fset *token.FileSet, inFile *ast.File, pkg *types.Package, typesInfo *types.Info) {
+		outFile := Simplify(inFile, typesInfo, &opts)
 		got := fprint(t, fset, outFile)
 		expected, err := ioutil.ReadFile(fmt.Sprintf("testdata/%s.expected.go", name))
 		if err != nil {
@@ -110,29 +140,329 @@ func TestSimplify(t *testing.T) {
 		if got != string(expected) {
 			t.Errorf("expected:\n%s\n--- got:\n%s\n", string(expected), got)
 		}
+	})
+}
+
+var testFiles = []struct {
+	name string
+	opts *Options
+}{
+	{"var", allLowerings(Options{})},
+	{"tuple", allLowerings(Options{})},
+	{"typeswitch", allLowerings(Options{LowerTypeSwitches: true})},
+	{"receive", allLowerings(Options{HoistReceives: true})},
+	{"range", allLowerings(Options{})},
+	{"generic", allLowerings(Options{})},
+	{"hygiene", allLowerings(Options{})},
+	{"lowerrange", allLowerings(Options{LowerRanges: true})},
+	{"loopvar", allLowerings(Options{LowerRanges: true})},
+	{"rangefunc", allLowerings(Options{LowerRanges: true})},
+}
+
+func TestCheckTempPrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{"": true, "_": true, "tmp": true, "tmp-": false, "1": false, "ä": true} {
+		if err := CheckTempPrefix(prefix); (err == nil) != valid {
+			t.Errorf("CheckTempPrefix(%q): expected valid to be %v, got %v", prefix, valid, err)
+		}
+	}
+}
+
+func TestGenericInstances(t *testing.T) {
+	fset := token.NewFileSet()
+	inFile, err := parser.ParseFile(fset, "testdata/generic.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, typesInfo := typeCheck(t, fset, inFile)
+
+	outFile := Simplify(inFile, typesInfo, allLowerings(Options{}))
+	ast.Inspect(outFile, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		var id *ast.Ident
+		switch fun := call.Fun.(type) {
+		case *ast.IndexExpr:
+			id, _ = fun.X.(*ast.Ident)
+		case *ast.IndexListExpr:
+			id, _ = fun.X.(*ast.Ident)
+		case *ast.Ident:
+			id = fun
+		}
+		if id == nil {
+			return true
+		}
+		if fn, ok := typesInfo.Uses[id].(*types.Func); ok && fn.Type().(*types.Signature).TypeParams() != nil {
+			if _, ok := typesInfo.Instances[id]; !ok {
+				t.Errorf("no instance recorded for call of %s at %s", id.Name, fset.Position(call.Pos()))
+			}
+		}
+		return true
+	})
+}
+
+func TestSimplifyPackage(t *testing.T) {
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, src := range []string{
+		"package main; var x = func() { f()() }; func main() { g(f()) }",
+		"package main; var y = func() { x() }; var w = z + 1; var z = k()(); func f() func() { return nil }; func g(func()) {}; func k() func() int { return nil }",
+	} {
+		files = append(files, parse(t, fset, src))
+	}
+	pkg, typesInfo := typeCheckPackage(t, &types.Config{}, "main", fset, files...)
+
+	outFiles, outInfo := SimplifyPackage(files, pkg, typesInfo, allLowerings(Options{}))
+	expected := []string{
+		"package main; var x = func() { _1 := f(); _1() }; func main() { _1 := f(); g(_1) }",
+		"package main; var y = func() { x() }; var w = z + 1; var z = func() int {\n_1 := k()\nreturn _1()\n}(); func f() func() { return nil }; func g(func()) {}; func k() func() int { return nil }",
+	}
+	for i, outFile := range outFiles {
+		if got, want := fprint(t, fset, outFile), fprint(t, fset, parse(t, fset, expected[i])); got != want {
+			t.Errorf("file %d: expected:\n%s\n--- got:\n%s\n", i, want, got)
+		}
+	}
+
+	values := make(map[ast.Expr]bool)
+	for _, outFile := range outFiles {
+		for _, decl := range outFile.Decls {
+			if genDecl, ok := decl.(*ast.GenDecl); ok {
+				for _, spec := range genDecl.Specs {
+					for _, v := range spec.(*ast.ValueSpec).Values {
+						values[v] = true
+					}
+				}
+			}
+		}
+	}
+	var order []string
+	for _, initializer := range outInfo.InitOrder {
+		if !values[initializer.Rhs] {
+			t.Errorf("initializer %s does not refer to the simplified file", initializer)
+		}
+		order = append(order, initializer.Lhs[0].Name())
+	}
+	if got := strings.Join(order, " "); got != "x y z w" {
+		t.Errorf("expected the initialization order x y z w, got %s", got)
+	}
+}
+
+func TestCopyInfo(t *testing.T) {
+	fset := token.NewFileSet()
+	file := parse(t, fset, "package main; import \"os\"; var x = func() { g(f(), 0) }; func main() { for i := range os.Args { g(f(), i) }; for range os.Args[0] {} }; func f() func() { return nil }; func g(func(), int) {}")
+	pkg, typesInfo := typeCheck(t, fset, file)
+	snapshot := func() string {
+		var buf bytes.Buffer
+		fmt.Fprintln(&buf, len(typesInfo.Types), len(typesInfo.Defs), len(typesInfo.Uses), len(typesInfo.Implicits), len(typesInfo.Selections), len(typesInfo.Scopes))
+		for _, initializer := range typesInfo.InitOrder {
+			fmt.Fprintf(&buf, "%p\n", initializer.Rhs)
+		}
+		var scopes []string
+		for _, scope := range typesInfo.Scopes {
+			scopes = append(scopes, fmt.Sprintln(scope.Names(), scope.NumChildren()))
+		}
+		sort.Strings(scopes)
+		fmt.Fprintln(&buf, pkg.Scope().NumChildren(), pkg.Imports())
+		return buf.String() + strings.Join(scopes, "")
+	}
+	before := snapshot()
+
+	for _, opts := range []*Options{
+		allLowerings(Options{CopyInfo: true, LowerRanges: true}),
+		{SimplifyCalls: true, CopyInfo: true},
+	} {
+		outFiles, outInfo := SimplifyPackage([]*ast.File{file}, pkg, typesInfo, opts)
+		if outInfo == typesInfo {
+			t.Fatal("expected a copy of the type information")
+		}
+		if after := snapshot(); after != before {
+			t.Fatalf("the type information changed from:\n%s\n--- to:\n%s", before, after)
+		}
+		ast.Inspect(outFiles[0], func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name != "_" && id.Name != "main" && outInfo.ObjectOf(id) == nil {
+				t.Errorf("no object for %s", id.Name)
+			}
+			return true
+		})
+		if outInfo.InitOrder[0].Rhs == typesInfo.InitOrder[0].Rhs {
+			t.Error("expected the initializer to refer to the simplified file")
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Simplify to reject CopyInfo")
+			}
+		}()
+		Simplify(file, typesInfo, &Options{CopyInfo: true})
+	}()
+}
+
+func TestPackageGoVersion(t *testing.T) {
+	src := "package main; func f(s []int) { for i := range s { _ = &i } }"
+	for _, test := range []struct {
+		goVersion string
+		shared    bool
+	}{
+		{"go1.21", true},
+		{"go1.22", false},
+		{"", false},
+	} {
+		fset := token.NewFileSet()
+		file := parse(t, fset, src)
+		pkg, typesInfo := typeCheckPackage(t, &types.Config{GoVersion: test.goVersion}, "main", fset, file)
+		outFiles, _ := SimplifyPackage([]*ast.File{file}, pkg, typesInfo, &Options{LowerRanges: true})
+		got := fprint(t, fset, outFiles[0])
+		if shared := strings.Contains(got, "i = _2"); shared != test.shared {
+			t.Errorf("%q: expected shared iteration variables to be %v, got:\n%s", test.goVersion, test.shared, got)
+		}
+	}
+}
+
+func TestNoImportCycle(t *testing.T) {
+	for _, test := range []struct{ path, src string }{
+		// the package itself
+		{"unicode/utf8", "package utf8; func f(s string) { for range s {} }"},
+		// a package imported by reflect
+		{"strconv", "package strconv; func f(m map[int]int) { for range m {} }"},
+	} {
+		fset := token.NewFileSet()
+		file := parse(t, fset, test.src)
+		pkg, typesInfo := typeCheckPackage(t, &types.Config{}, test.path, fset, file)
+		outFiles, _ := SimplifyPackage([]*ast.File{file}, pkg, typesInfo, &Options{LowerRanges: true})
+		if got, want := fprint(t, fset, outFiles[0]), fprint(t, fset, parse(t, fset, test.src)); got != want {
+			t.Errorf("%s: expected the range loop to be kept, got:\n%s", test.path, got)
+		}
+	}
+}
+
+func TestTemporaryObjects(t *testing.T) {
+	src := `package main
+
+func main() {
+	for i := 0; i < f()(); i = g(h()) {
+		switch f()() {
+		case 1, 2:
+			_ = i > 0 && f()() > 0
+		}
+	}
+	var x int
+	for x = range makeChan() {
+		_ = x
+	}
+	for i, r := range "abc" {
+		_, _ = i, r
+	}
+	for k, v := range map[int]int{} {
+		if k == v {
+			defer f()()
+			return
+		}
+	}
+	for i := range g(h()) {
+		for x := range func(yield func(int) bool) {} {
+			if x == i {
+				break
+			}
+		}
+	}
+	select {
+	case s().f = <-makeChan():
+	}
+}
+
+func f() func() int { return nil }
+func g(int, int) int { return 0 }
+func h() (int, int) { return 0, 0 }
+func s() *struct{ f int } { return nil }
+func makeChan() chan int { return nil }
+`
+	fset := token.NewFileSet()
+	inFile := parse(t, fset, src)
+	_, typesInfo := typeCheck(t, fset, inFile)
+	original := make(map[types.Object]bool)
+	for _, obj := range typesInfo.Defs {
+		original[obj] = true
+	}
+
+	outFile := Simplify(inFile, typesInfo, allLowerings(Options{LowerRanges: true}))
+	defs := make(map[types.Object]int)
+	uses := make(map[types.Object]int)
+	ast.Inspect(outFile, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Name == "_" {
+			return true
+		}
+		if obj := typesInfo.Defs[id]; obj != nil && !original[obj] {
+			defs[obj]++
+			if !obj.Pos().IsValid() {
+				t.Errorf("%s has no position", obj.Name())
+			}
+			if obj.Parent() == nil || obj.Parent().Lookup(obj.Name()) != obj {
+				t.Errorf("%s is not declared in its parent scope", obj.Name())
+			}
+			if obj.Type() == nil {
+				t.Errorf("%s has no type", obj.Name())
+			}
+			return true
+		}
+		if obj := typesInfo.Uses[id]; obj != nil && !original[obj] {
+			if _, isVar := obj.(*types.Var); isVar {
+				uses[obj]++
+			}
+		}
+		return true
+	})
+	if len(defs) == 0 {
+		t.Fatal("no temporaries found")
 	}
+	for obj, n := range defs {
+		if n != 1 {
+			t.Errorf("%s is defined %d times", obj.Name(), n)
+		}
+		if uses[obj] == 0 {
+			t.Errorf("%s is never used", obj.Name())
+		}
+	}
+	for obj := range uses {
+		if defs[obj] == 0 {
+			t.Errorf("%s is used but not defined", obj.Name())
+		}
+	}
+}
+
+// allLowerings returns opts with call simplification and all lowerings that
+// go along with it enabled.
+func allLowerings(opts Options) *Options {
+	opts.SimplifyCalls = true
+	opts.LowerShortCircuits = true
+	opts.SplitTuples = true
+	opts.LowerSwitches = true
+	opts.LowerChanRanges = true
+	opts.HoistSelectOperands = true
+	return &opts
 }
 
 func simplifyAndCompareStmts(t *testing.T, in, out string) {
+	simplifyAndCompareStmtsWithOptions(t, allLowerings(Options{}), in, out)
+}
+
+func simplifyAndCompareStmtsWithOptions(t *testing.T, opts *Options, in, out string) {
 	inFile := "package main; func main() { " + in + " }"
 	outFile := "package main; func main() { " + out + " }"
-	simplifyAndCompare(t, inFile, outFile)
-	simplifyAndCompare(t, outFile, outFile)
+	simplifyAndCompare(t, opts, inFile, outFile)
+	simplifyAndCompare(t, opts, outFile, outFile)
 }
 
-func simplifyAndCompare(t *testing.T, in, out string) {
+func simplifyAndCompare(t *testing.T, opts *Options, in, out string) {
 	fset := token.NewFileSet()
 
 	expected := fprint(t, fset, parse(t, fset, out))
 
 	inFile := parse(t, fset, in)
-	typesInfo := &types.Info{
-		Types:  make(map[ast.Expr]types.TypeAndValue),
-		Defs:   make(map[*ast.Ident]types.Object),
-		Uses:   make(map[*ast.Ident]types.Object),
-		Scopes: make(map[ast.Node]*types.Scope),
-	}
-	outFile := Simplify(inFile, typesInfo, true)
+	outFile := Simplify(inFile, newInfo(), opts)
 	got := fprint(t, fset, outFile)
 
 	if got != expected {
@@ -140,6 +470,51 @@ func simplifyAndCompare(t *testing.T, in, out string) {
 	}
 }
 
+// newInfo returns a types.Info with all the maps that the simplification keeps
+// up to date.
+func newInfo() *types.Info {
+	return &types.Info{
+		Types:        make(map[ast.Expr]types.TypeAndValue),
+		Defs:         make(map[*ast.Ident]types.Object),
+		Uses:         make(map[*ast.Ident]types.Object),
+		Implicits:    make(map[ast.Node]types.Object),
+		Selections:   make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:       make(map[ast.Node]*types.Scope),
+		Instances:    make(map[*ast.Ident]types.Instance),
+		FileVersions: make(map[*ast.File]string),
+	}
+}
+
+// typeCheck type-checks file as the package main.
+func typeCheck(t *testing.T, fset *token.FileSet, file *ast.File) (*types.Package, *types.Info) {
+	return typeCheckPackage(t, &types.Config{Importer: importer.Default()}, "main", fset, file)
+}
+
+// typeCheckPackage type-checks files as the package with the given path
+// according to config.
+func typeCheckPackage(t *testing.T, config *types.Config, path string, fset *token.FileSet, files ...*ast.File) (*types.Package, *types.Info) {
+	info := newInfo()
+	pkg, err := config.Check(path, fset, files, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg, info
+}
+
+// forEachTestFile calls f for each of testFiles with the parsed and
+// type-checked file and a copy of its options.
+func forEachTestFile(t *testing.T, f func(name string, opts Options, fset *token.FileSet, file *ast.File, pkg *types.Package, info *types.Info)) {
+	for _, test := range testFiles {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, fmt.Sprintf("testdata/%s.go", test.name), nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, info := typeCheck(t, fset, file)
+		f(test.name, *test.opts, fset, file, pkg, info)
+	}
+}
+
 func parse(t *testing.T, fset *token.FileSet, body string) *ast.File {
 	file, err := parser.ParseFile(fset, "", body, 0)
 	if err != nil {
@@ -177,6 +552,30 @@ func TestContainsCall(t *testing.T) {
 	testContainsCall(t, "&a()", true)
 	testContainsCall(t, "a() + b", true)
 	testContainsCall(t, "a + b()", true)
+	testContainsCall(t, "a[b, c]", false)
+	testContainsCall(t, "a()[b, c]", true)
+}
+
+func TestContainsBlockingOp(t *testing.T) {
+	testContainsBlockingOp(t, "a", false)
+	testContainsBlockingOp(t, "a()", true)
+	testContainsBlockingOp(t, "<-a", true)
+	testContainsBlockingOp(t, "-<-a", true)
+	testContainsBlockingOp(t, "T{a: <-b}", true)
+	testContainsBlockingOp(t, "a[<-b]", true)
+	testContainsBlockingOp(t, "a && <-b", true)
+	testContainsBlockingOp(t, "func() int { return <-a }", false)
+	testContainsBlockingOp(t, "&a", false)
+}
+
+func testContainsBlockingOp(t *testing.T, in string, expected bool) {
+	x, err := parser.ParseExpr(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ContainsBlockingOp(x); got != expected {
+		t.Errorf("ContainsBlockingOp(%s): expected %t, got %t", in, expected, got)
+	}
 }
 
 func testContainsCall(t *testing.T, in string, expected bool) {
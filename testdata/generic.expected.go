@@ -0,0 +1,61 @@
+package main
+
+func main() {
+	_1 := makeSlice()
+	_2 := makeMapper()
+	_ = Map[int, string](_1, _2)
+	_3 := makeInt()
+	b := Box[int]{_3}
+	_4 := b.Get()
+	_4()
+	_5 := makeChan()
+	Recv(_5)
+}
+
+type Box[T any] struct {
+	Value T
+}
+
+func (b Box[T]) Get() func() T {
+	return func() T { return b.Value }
+}
+
+func Map[T, U any](s []T, f func(T) U) []U {
+	_1 := len(s)
+	r := make([]U, _1)
+	for i, x := range s {
+		r[i] = f(x)
+	}
+	return r
+}
+
+func Recv[C ~chan T | ~<-chan T, T any](c C) {
+	_2 := c
+	for {
+		x, _1 := <-_2
+		if !_1 {
+			break
+		}
+		Map([]T{x}, Identity[T])
+	}
+}
+
+func Identity[T any](x T) T {
+	return x
+}
+
+func makeSlice() []int {
+	return nil
+}
+
+func makeMapper() func(int) string {
+	return nil
+}
+
+func makeInt() int {
+	return 0
+}
+
+func makeChan() chan int {
+	return nil
+}
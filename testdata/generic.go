@@ -0,0 +1,50 @@
+package main
+
+func main() {
+	_ = Map[int, string](makeSlice(), makeMapper())
+	b := Box[int]{makeInt()}
+	b.Get()()
+	Recv(makeChan())
+}
+
+type Box[T any] struct {
+	Value T
+}
+
+func (b Box[T]) Get() func() T {
+	return func() T { return b.Value }
+}
+
+func Map[T, U any](s []T, f func(T) U) []U {
+	r := make([]U, len(s))
+	for i, x := range s {
+		r[i] = f(x)
+	}
+	return r
+}
+
+func Recv[C ~chan T | ~<-chan T, T any](c C) {
+	for x := range c {
+		Map([]T{x}, Identity[T])
+	}
+}
+
+func Identity[T any](x T) T {
+	return x
+}
+
+func makeSlice() []int {
+	return nil
+}
+
+func makeMapper() func(int) string {
+	return nil
+}
+
+func makeInt() int {
+	return 0
+}
+
+func makeChan() chan int {
+	return nil
+}
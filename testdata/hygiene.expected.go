@@ -0,0 +1,23 @@
+package main
+
+var _1 = 0
+
+func main() {
+	_3 := 0
+	_2 := f()
+	_5 := _2()
+	_ = _5 + _1
+	func() { _6 := f(); _7 := _6(); _ = _7 + _3 }()
+	{
+		_8 := f()
+		x := _8()
+		if x > 0 {
+			_4 := x
+			_ = _4
+		}
+	}
+}
+
+func f() func() int {
+	return nil
+}
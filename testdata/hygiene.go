@@ -0,0 +1,19 @@
+package main
+
+var _1 = 0
+
+func main() {
+	_3 := 0
+	_ = f()() + _1
+	func() {
+		_ = f()() + _3
+	}()
+	if x := f()(); x > 0 {
+		_4 := x
+		_ = _4
+	}
+}
+
+func f() func() int {
+	return nil
+}
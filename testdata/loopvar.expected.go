@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"unicode/utf8"
+)
+
+func slices(s []int) (fs []func() int) {
+	for _1, _2, i, x := s, 0, 0, 0; _2 < len(_1); _2++ {
+		i, x = _2, _1[_2]
+		fs = append(fs, func() int { return i + x })
+	}
+	return
+}
+
+func strings(s string) (fs []func() rune) {
+	for _1, _2, r := s, 0, *new(rune); _2 < len(_1); {
+		_3, _4 := utf8.DecodeRuneInString(_1[_2:])
+		r = _3
+		_2 += _4
+		fs = append(fs, func() rune { return r })
+	}
+	return
+}
+
+func maps(m map[string]int) (fs []func() string) {
+	for _1, k := reflect.ValueOf(m).MapRange(), *new(string); ; {
+		_2 := _1.Next()
+		if !_2 {
+			break
+		}
+		_3 := _1.Key()
+		_4 := _3.Interface()
+		_5, _ := _4.(string)
+		k = _5
+		fs = append(fs, func() string { return k })
+	}
+	return
+}
+
+func channels(ch chan []int) (fs []func() []int) {
+	_3 := ch
+	for x := *new([]int); ; {
+		_1, _2 := <-_3
+		if !_2 {
+			break
+		}
+		x = _1
+		fs = append(fs, func() []int { return x })
+	}
+	return
+}
+
+func assigned(ch chan int) (x int) {
+	_3 := ch
+	for {
+		_1, _2 := <-_3
+		if !_2 {
+			break
+		}
+		x = _1
+	}
+	return
+}
+
+type entry struct{ n int }
+
+func shadowing(m map[string]entry) (fs []func() int) {
+	for _, entry := range m {
+		fs = append(fs, func() int { return entry.n })
+	}
+	return
+}
@@ -0,0 +1,46 @@
+//go:build go1.21
+
+package main
+
+func slices(s []int) (fs []func() int) {
+	for i, x := range s {
+		fs = append(fs, func() int { return i + x })
+	}
+	return
+}
+
+func strings(s string) (fs []func() rune) {
+	for _, r := range s {
+		fs = append(fs, func() rune { return r })
+	}
+	return
+}
+
+func maps(m map[string]int) (fs []func() string) {
+	for k := range m {
+		fs = append(fs, func() string { return k })
+	}
+	return
+}
+
+func channels(ch chan []int) (fs []func() []int) {
+	for x := range ch {
+		fs = append(fs, func() []int { return x })
+	}
+	return
+}
+
+func assigned(ch chan int) (x int) {
+	for x = range ch {
+	}
+	return
+}
+
+type entry struct{ n int }
+
+func shadowing(m map[string]entry) (fs []func() int) {
+	for _, entry := range m {
+		fs = append(fs, func() int { return entry.n })
+	}
+	return
+}
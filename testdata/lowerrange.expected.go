@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"unicode/utf8"
+)
+
+func slices(s []int, a [3]string, p *[3]string) {
+	for _1, _2 := s, 0; _2 < len(_1); _2++ {
+		i, x := _2, _1[_2]
+		fmt.Println(i, x)
+	}
+	for _3 := 0; _3 < len(a); _3++ {
+		i := _3
+		fmt.Println(i)
+	}
+	for _4, _5 := p, 0; _5 < len(_4); _5++ {
+		x := _4[_5]
+		fmt.Println(x)
+	}
+	for _6, _7 := s, 0; _7 < len(_6); _7++ {
+	}
+}
+
+func strings(s string) (n int) {
+	for _1, _2 := s, 0; _2 < len(_1); {
+		_3, _4 := utf8.DecodeRuneInString(_1[_2:])
+		i, r := _2, _3
+		_2 += _4
+		_5 := int(r)
+		n += i + _5
+	}
+	var r rune
+	for _6, _7 := s, 0; _7 < len(_6); {
+		_8, _9 := utf8.DecodeRuneInString(_6[_7:])
+		r = _8
+		_7 += _9
+	}
+	_10 := int(r)
+	return n + _10
+}
+
+func maps(m map[string]int) (string, error) {
+	for _1 := reflect.ValueOf(m).MapRange(); ; {
+		_2 := _1.Next()
+		if !_2 {
+			break
+		}
+		_3 := _1.Key()
+		_4 := _3.Interface()
+		_5, _ := _4.(string)
+		_6 := _1.Value()
+		_7 := _6.Interface()
+		_8, _ := _7.(int)
+		k, v := _5, _8
+		if v < 0 {
+			continue
+		}
+		if v == 0 {
+			break
+		}
+		defer fmt.Println(k)
+		if v > 100 {
+			return k, nil
+		}
+	}
+	_9 := fmt.Errorf("not found")
+	return "", _9
+}
+
+func labeled(m map[int]bool, s []int) {
+outer:
+	for _1, _2 := s, 0; _2 < len(_1); _2++ {
+		x := _1[_2]
+		for _3 := reflect.ValueOf(m).MapRange(); ; {
+			_4 := _3.Next()
+			if !_4 {
+				break
+			}
+			_5 := _3.Key()
+			_6 := _5.Interface()
+			_7, _ := _6.(int)
+			k := _7
+			if k == x {
+				continue outer
+			}
+		}
+	}
+}
+
+func interfaces(m map[any]error) (n int) {
+	for _1 := reflect.ValueOf(m).MapRange(); ; {
+		_2 := _1.Next()
+		if !_2 {
+			break
+		}
+		_3 := _1.Key()
+		_4 := _3.Interface()
+		_5, _ := _4.(any)
+		_6 := _1.Value()
+		_7 := _6.Interface()
+		_8, _ := _7.(error)
+		k, err := _5, _8
+		if k == nil && err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func pointers() {
+	var ptr *[4]int
+	for _1 := 0; _1 < len(ptr); _1++ {
+		i := _1
+		fmt.Println(i)
+	}
+}
+
+type entry struct{ n int }
+
+func shadowing(m map[string]entry) (n int) {
+	for _1 := reflect.ValueOf(m).MapRange(); ; {
+		_2 := _1.Next()
+		if !_2 {
+			break
+		}
+		_3 := _1.Value()
+		_4 := _3.Interface()
+		_5, _ := _4.(entry)
+		entry := _5
+		n += entry.n
+	}
+	return n
+}
+
+func jumps(s []int, str string, n int) {
+	if s == nil {
+		goto end
+	}
+	for _1, _2 := s, 0; _2 < len(_1); _2++ {
+	}
+	for _3, _4 := str, 0; _4 < len(_3); {
+		_, _5 := utf8.DecodeRuneInString(_3[_4:])
+		_4 += _5
+	}
+	for _6, _7 := n, 0; _7 < _6; _7++ {
+	}
+end:
+}
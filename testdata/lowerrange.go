@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+func slices(s []int, a [3]string, p *[3]string) {
+	for i, x := range s {
+		fmt.Println(i, x)
+	}
+	for i := range a {
+		fmt.Println(i)
+	}
+	for _, x := range p {
+		fmt.Println(x)
+	}
+	for range s {
+	}
+}
+
+func strings(s string) (n int) {
+	for i, r := range s {
+		n += i + int(r)
+	}
+	var r rune
+	for _, r = range s {
+	}
+	return n + int(r)
+}
+
+func maps(m map[string]int) (string, error) {
+	for k, v := range m {
+		if v < 0 {
+			continue
+		}
+		if v == 0 {
+			break
+		}
+		defer fmt.Println(k)
+		if v > 100 {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("not found")
+}
+
+func labeled(m map[int]bool, s []int) {
+outer:
+	for _, x := range s {
+		for k := range m {
+			if k == x {
+				continue outer
+			}
+		}
+	}
+}
+
+func interfaces(m map[any]error) (n int) {
+	for k, err := range m {
+		if k == nil && err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func pointers() {
+	var ptr *[4]int
+	for i := range ptr {
+		fmt.Println(i)
+	}
+}
+
+type entry struct{ n int }
+
+func shadowing(m map[string]entry) (n int) {
+	for _, entry := range m {
+		n += entry.n
+	}
+	return n
+}
+
+func jumps(s []int, str string, n int) {
+	if s == nil {
+		goto end
+	}
+	for range s {
+	}
+	for range str {
+	}
+	for range n {
+	}
+end:
+}
@@ -27,16 +27,25 @@ func test2() {
 
 func test3() {
 	var x int
-	var _1 bool
-	_2 := makeChan()
+	_3 := makeChan()
 	for {
-		x, _1 = <-_2
-		if !_1 {
+		_1, _2 := <-_3
+		if !_2 {
 			break
 		}
+		x = _1
+		_ = x
+		_4 := makeFunc()
+		_4()
+	}
+}
+
+func test4() {
+	_1 := makeSlices()
+	_2 := makeFunc()
+	_3 := _2()
+	for x := range _1[_3] {
 		_ = x
-		_3 := makeFunc()
-		_3()
 	}
 }
 
@@ -47,3 +56,7 @@ func makeChan() <-chan int {
 func makeFunc() func() int {
 	return nil
 }
+
+func makeSlices() [][]int {
+	return nil
+}
@@ -21,6 +21,12 @@ func test3() {
 	}
 }
 
+func test4() {
+	for x := range makeSlices()[makeFunc()()] {
+		_ = x
+	}
+}
+
 func makeChan() <-chan int {
 	return nil
 }
@@ -28,3 +34,7 @@ func makeChan() <-chan int {
 func makeFunc() func() int {
 	return nil
 }
+
+func makeSlices() [][]int {
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+)
+
+type counter uint8
+
+func ints(n int) (sum int) {
+	for _1 := 0; _1 < 10; _1++ {
+		i := _1
+		sum += i
+	}
+	var c counter
+	for _2, _3 := counter(n), *new(counter); _3 < _2; _3++ {
+		c = _3
+	}
+	for _4, _5 := n, 0; _5 < _4; _5++ {
+		sum++
+	}
+	_6 := int(c)
+	return sum + _6
+}
+
+func find(seq iter.Seq2[int, string], s string) (int, error) {
+	{
+		var _1 int
+		var _2 error
+		_3 := 0
+		seq(func(i int, x string) bool {
+			if x == "" {
+				return true
+			}
+			if x == s {
+				_1, _2 = i, nil
+				_3 = 1
+				return false
+			}
+			return true
+		})
+		if _3 == 1 {
+			return _1, _2
+		}
+	}
+	_4 := fmt.Errorf("not found")
+	return 0, _4
+}
+
+func labeled(seq iter.Seq[int], s []int) (n int) {
+outer:
+	for _1, _2 := s, 0; _2 < len(_1); _2++ {
+		x := _1[_2]
+		{
+			_3 := 0
+			var _5 []func()
+			defer func() {
+				for _6 := 0; _6 < len(_5); _6++ {
+					defer _5[_6]()
+				}
+			}()
+			seq(func(y int) bool {
+				if y == x {
+					_3 = 1
+					return false
+				}
+				if y > x {
+					_3 = 2
+					return false
+				}
+				_4 := y
+				_5 = append(_5, func() {
+					fmt.Println(_4)
+				})
+				return true
+			})
+			if _3 == 1 {
+				continue outer
+			}
+			if _3 == 2 {
+
+				break outer
+			}
+		}
+
+		n++
+	}
+	return
+}
+
+type item struct{ n int }
+
+func shadowed(seq iter.Seq2[string, item]) (n int) {
+	seq(func(_ string, item item) bool {
+		n += item.n
+		return true
+	})
+	return n
+}
+
+func jumps(seq iter.Seq[int]) int {
+	if seq == nil {
+		goto end
+	}
+	{
+		var _1 int
+		_2 := 0
+		seq(func(x int) bool {
+			if x > 1 {
+				_1 = x
+				_2 = 1
+				return false
+			}
+			return true
+		})
+		if _2 == 1 {
+			return _1
+		}
+	}
+
+end:
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+)
+
+type counter uint8
+
+func ints(n int) (sum int) {
+	for i := range 10 {
+		sum += i
+	}
+	var c counter
+	for c = range counter(n) {
+	}
+	for range n {
+		sum++
+	}
+	return sum + int(c)
+}
+
+func find(seq iter.Seq2[int, string], s string) (int, error) {
+	for i, x := range seq {
+		if x == "" {
+			continue
+		}
+		if x == s {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("not found")
+}
+
+func labeled(seq iter.Seq[int], s []int) (n int) {
+outer:
+	for _, x := range s {
+		for y := range seq {
+			if y == x {
+				continue outer
+			}
+			if y > x {
+				break outer
+			}
+			defer fmt.Println(y)
+		}
+		n++
+	}
+	return
+}
+
+type item struct{ n int }
+
+func shadowed(seq iter.Seq2[string, item]) (n int) {
+	for _, item := range seq {
+		n += item.n
+	}
+	return n
+}
+
+func jumps(seq iter.Seq[int]) int {
+	if seq == nil {
+		goto end
+	}
+	for x := range seq {
+		if x > 1 {
+			return x
+		}
+	}
+end:
+	return 0
+}
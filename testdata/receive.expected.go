@@ -0,0 +1,62 @@
+package main
+
+var ch = make(chan int, 10)
+
+var x = func() int {
+	_1 := <-ch
+	return _1 + 1
+}()
+var y, yok = <-ch
+
+func main() {
+	_1 := <-ch
+	_2 := g()
+	f(_1, _2)
+	var v, ok = <-ch
+	_3 := len(ch)
+	f(v, _3)
+	_4 := ok
+	if _4 {
+		_5 := <-ch
+		_4 = _5 > 0
+	}
+	if _4 {
+		_6 := <-ch
+		f(_6, 0)
+	}
+	_7 := ok
+	if !_7 {
+		_8 := <-ch
+		_7 = _8 == 0
+	}
+	b := _7
+	_ = b
+	for {
+		_9 := <-ch
+		if !(_9 > 0) {
+			break
+		}
+	}
+	_10 := make(chan int)
+	_11 := <-ch
+	select {
+	case v := <-_10:
+		_ = v
+	case ch <- _11:
+	default:
+	}
+
+	c := make(chan chan int, 1)
+	c <- ch
+	_12 := <-c
+	_13 := <-_12
+	f(_13, 0)
+}
+
+func f(a, b int) {
+}
+
+func g() int {
+	_1 := <-ch
+	return _1
+}
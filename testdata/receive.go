@@ -0,0 +1,35 @@
+package main
+
+var ch = make(chan int, 10)
+
+var x = <-ch + 1
+var y, yok = <-ch
+
+func main() {
+	f(<-ch, g())
+	var v, ok = <-ch
+	f(v, len(ch))
+	if ok && <-ch > 0 {
+		f(<-ch, 0)
+	}
+	b := ok || <-ch == 0
+	_ = b
+	for <-ch > 0 {
+	}
+	select {
+	case v := <-make(chan int):
+		_ = v
+	case ch <- <-ch:
+	default:
+	}
+	c := make(chan chan int, 1)
+	c <- ch
+	f(<-<-c, 0)
+}
+
+func f(a, b int) {
+}
+
+func g() int {
+	return <-ch
+}
@@ -1,8 +1,20 @@
 package main
 
+var p, q = f()
+var r, s = func() (int, int) {
+	_1, _2 := f()
+	return k(_1, _2)
+}()
+
 func main() {
 	_1, _2 := f()
 	g(_1, _2)
+	_3, _4 := f()
+	var a, b = _3, _4
+	g(a, b)
+	var m map[int]int
+	var v, ok = m[0]
+	_, _ = v, ok
 }
 
 func f() (int, int) {
@@ -11,3 +23,12 @@ func f() (int, int) {
 
 func g(x, y int) {
 }
+
+func h() (int, int) {
+	_1, _2 := f()
+	return _1, _2
+}
+
+func k(x, y int) (int, int) {
+	return x, y
+}
@@ -1,7 +1,16 @@
 package main
 
+// the values of package-level variables stay together
+var p, q = f()
+var r, s = k(f())
+
 func main() {
 	g(f())
+	var a, b = f()
+	g(a, b)
+	var m map[int]int
+	var v, ok = m[0]
+	_, _ = v, ok
 }
 
 func f() (int, int) {
@@ -10,3 +19,11 @@ func f() (int, int) {
 
 func g(x, y int) {
 }
+
+func h() (int, int) {
+	return f()
+}
+
+func k(x, y int) (int, int) {
+	return x, y
+}
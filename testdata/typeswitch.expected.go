@@ -0,0 +1,108 @@
+package main
+
+import "fmt"
+
+type stringer interface {
+	String() string
+}
+
+func main() {
+	_1 := fmt.Errorf("e")
+	for _, x := range []interface{}{1, "a", nil, 2.5, _1} {
+		describe(x)
+	}
+}
+
+func describe(x interface{}) {
+	switch {
+	default:
+		_1 := x
+		if _2, _3 := _1.(int); _3 {
+			v := _2
+			_4 := get(v)
+			fmt.Println("int", _4)
+		} else {
+			_, _5 := _1.(string)
+			if !_5 {
+				_, _5 = _1.(error)
+			}
+			if _5 {
+				v := _1
+				fmt.Println("string or error", v)
+			} else if _1 == nil {
+				v := _1
+				fmt.Println("nil", v)
+			} else if _, _6 := _1.(stringer); _6 {
+				fmt.Println("stringer")
+			} else {
+				v := _1
+
+				fmt.Println("other", v)
+			}
+		}
+	}
+
+	switch {
+	default:
+		y := get(x)
+		_7 := y
+		_, _8 := _7.(int)
+		if !_8 {
+			_8 = _7 == nil
+		}
+		if _8 {
+			fmt.Println("int or nil", y)
+		}
+	}
+
+	switch {
+	default:
+		_ = x
+
+		fmt.Println("only default")
+	}
+
+loop:
+	switch {
+	default:
+		_9 := get(x)
+		if _10, _11 := _9.(float64); _11 {
+			v := _10
+			if v > 1 {
+				break loop
+			}
+			fmt.Println("small float")
+		} else {
+			_12 := _9 == nil
+			if !_12 {
+				_, _12 = _9.(int)
+			}
+			if _12 {
+				v := _9
+				_ = v
+			}
+		}
+	}
+
+}
+
+func get(x interface{}) interface{} {
+	return x
+}
+
+type T struct{}
+
+func shadowing(x interface{}) {
+	switch {
+	default:
+		_1 := x
+		if _2, _3 := _1.(int); _3 {
+			T := _2
+			fmt.Println("int", T)
+		} else if _4, _5 := _1.(T); _5 {
+			T := _4
+			fmt.Println("T", T)
+		}
+	}
+
+}
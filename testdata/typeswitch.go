@@ -0,0 +1,64 @@
+package main
+
+import "fmt"
+
+type stringer interface {
+	String() string
+}
+
+func main() {
+	for _, x := range []interface{}{1, "a", nil, 2.5, fmt.Errorf("e")} {
+		describe(x)
+	}
+}
+
+func describe(x interface{}) {
+	switch v := x.(type) {
+	case int:
+		fmt.Println("int", get(v))
+	case string, error:
+		fmt.Println("string or error", v)
+	case nil:
+		fmt.Println("nil", v)
+	case stringer:
+		fmt.Println("stringer")
+	default:
+		fmt.Println("other", v)
+	}
+
+	switch y := get(x); y.(type) {
+	case int, nil:
+		fmt.Println("int or nil", y)
+	}
+
+	switch x.(type) {
+	default:
+		fmt.Println("only default")
+	}
+
+loop:
+	switch v := get(x).(type) {
+	case float64:
+		if v > 1 {
+			break loop
+		}
+		fmt.Println("small float")
+	case nil, int:
+		_ = v
+	}
+}
+
+func get(x interface{}) interface{} {
+	return x
+}
+
+type T struct{}
+
+func shadowing(x interface{}) {
+	switch T := x.(type) {
+	case int:
+		fmt.Println("int", T)
+	case T:
+		fmt.Println("T", T)
+	}
+}
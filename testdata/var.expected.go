@@ -5,3 +5,49 @@ var x = func() { _1 := f(); _1() }
 func f() func() {
 	return nil
 }
+
+var y = func() int {
+	_1 := f2()
+	return _1()
+}()
+
+var a, b = func() bool {
+	_1 := f2()
+	_2 := _1()
+	return g(_2)
+}(), func() bool {
+	_3 := g(0)
+	if _3 {
+		_3 = h()
+	}
+	return _3
+}()
+
+var c, d = func() (int, string) {
+	_1 := g(1)
+	return pair(_1)
+}()
+
+var e mybool = func() mybool {
+	_1 := g(1)
+	_2 := h()
+	return _1 == _2
+}()
+
+type mybool bool
+
+func f2() func() int {
+	return nil
+}
+
+func g(int) bool {
+	return false
+}
+
+func h() bool {
+	return false
+}
+
+func pair(bool) (int, string) {
+	return 0, ""
+}
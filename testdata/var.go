@@ -5,3 +5,29 @@ var x = func() { f()() }
 func f() func() {
 	return nil
 }
+
+var y = f2()()
+
+var a, b = g(f2()()), g(0) && h()
+
+var c, d = pair(g(1))
+
+var e mybool = g(1) == h()
+
+type mybool bool
+
+func f2() func() int {
+	return nil
+}
+
+func g(int) bool {
+	return false
+}
+
+func h() bool {
+	return false
+}
+
+func pair(bool) (int, string) {
+	return 0, ""
+}
@@ -0,0 +1,336 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// typeExpr returns an expression that denotes t in the current scope, placed
+// at pos. It returns nil if t can not be denoted there, e.g. because it refers
+// to an unexported type of another package. Packages that t refers to get
+// imported into the current file, so canDenote should be checked before
+// generating code that may get discarded.
+func (c *simplifyContext) typeExpr(t types.Type, pos token.Pos) ast.Expr {
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.UntypedBool, types.UntypedInt, types.UntypedRune, types.UntypedFloat, types.UntypedComplex, types.UntypedString:
+			return c.typeExpr(types.Default(t), pos)
+		case types.UnsafePointer:
+			return c.qualifiedIdent(types.Unsafe, "Pointer")
+		case types.Invalid, types.UntypedNil:
+			return nil
+		}
+		return c.universeTypeIdent(t.Name())
+
+	case *types.Alias:
+		return c.namedTypeExpr(t.Obj(), t.TypeArgs(), pos)
+
+	case *types.Named:
+		return c.namedTypeExpr(t.Obj(), t.TypeArgs(), pos)
+
+	case *types.TypeParam:
+		return c.typeNameIdent(t.Obj())
+
+	case *types.Pointer:
+		elem := c.typeExpr(t.Elem(), pos)
+		if elem == nil {
+			return nil
+		}
+		return &ast.StarExpr{X: elem}
+
+	case *types.Slice:
+		elem := c.typeExpr(t.Elem(), pos)
+		if elem == nil {
+			return nil
+		}
+		return &ast.ArrayType{Elt: elem}
+
+	case *types.Array:
+		elem := c.typeExpr(t.Elem(), pos)
+		if elem == nil {
+			return nil
+		}
+		return &ast.ArrayType{
+			Len: &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(t.Len(), 10)},
+			Elt: elem,
+		}
+
+	case *types.Map:
+		key := c.typeExpr(t.Key(), pos)
+		elem := c.typeExpr(t.Elem(), pos)
+		if key == nil || elem == nil {
+			return nil
+		}
+		return &ast.MapType{Key: key, Value: elem}
+
+	case *types.Chan:
+		elem := c.typeExpr(t.Elem(), pos)
+		if elem == nil {
+			return nil
+		}
+		dir := ast.SEND | ast.RECV
+		switch t.Dir() {
+		case types.SendOnly:
+			dir = ast.SEND
+		case types.RecvOnly:
+			dir = ast.RECV
+		}
+		return &ast.ChanType{Dir: dir, Value: elem}
+
+	case *types.Signature:
+		params := c.fieldList(t.Params(), t.Variadic(), pos)
+		results := c.fieldList(t.Results(), false, pos)
+		if params == nil || results == nil {
+			return nil
+		}
+		if len(results.List) == 0 {
+			results = nil
+		}
+		return &ast.FuncType{Params: params, Results: results}
+
+	case *types.Struct:
+		fields := &ast.FieldList{Opening: pos, Closing: pos}
+		for i := 0; i < t.NumFields(); i++ {
+			f := t.Field(i)
+			if !f.Exported() && f.Pkg() != c.pkg {
+				return nil
+			}
+			typ := c.typeExpr(f.Type(), pos)
+			if typ == nil {
+				return nil
+			}
+			field := &ast.Field{Type: typ}
+			if !f.Embedded() {
+				field.Names = []*ast.Ident{ast.NewIdent(f.Name())}
+			}
+			if tag := t.Tag(i); tag != "" {
+				field.Tag = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(tag)}
+			}
+			fields.List = append(fields.List, field)
+		}
+		return &ast.StructType{Fields: fields}
+
+	case *types.Interface:
+		methods := &ast.FieldList{Opening: pos, Closing: pos}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			var typ ast.Expr
+			if union, ok := t.EmbeddedType(i).(*types.Union); ok {
+				typ = c.unionExpr(union, pos)
+			} else {
+				typ = c.typeExpr(t.EmbeddedType(i), pos)
+			}
+			if typ == nil {
+				return nil
+			}
+			methods.List = append(methods.List, &ast.Field{Type: typ})
+		}
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			m := t.ExplicitMethod(i)
+			if !m.Exported() && m.Pkg() != c.pkg {
+				return nil
+			}
+			typ := c.typeExpr(m.Type(), pos)
+			if typ == nil {
+				return nil
+			}
+			methods.List = append(methods.List, &ast.Field{
+				Names: []*ast.Ident{ast.NewIdent(m.Name())},
+				Type:  typ,
+			})
+		}
+		return &ast.InterfaceType{Methods: methods}
+
+	default:
+		return nil
+	}
+}
+
+func (c *simplifyContext) namedTypeExpr(obj *types.TypeName, typeArgs *types.TypeList, pos token.Pos) ast.Expr {
+	var x ast.Expr
+	switch {
+	case obj.Pkg() == nil:
+		x = c.universeTypeIdent(obj.Name())
+	case obj.Pkg() == c.pkg:
+		x = c.typeNameIdent(obj)
+	case obj.Exported():
+		x = c.qualifiedIdent(obj.Pkg(), obj.Name())
+	}
+	if x == nil || typeArgs.Len() == 0 {
+		return x
+	}
+	indices := make([]ast.Expr, typeArgs.Len())
+	for i := range indices {
+		indices[i] = c.typeExpr(typeArgs.At(i), pos)
+		if indices[i] == nil {
+			return nil
+		}
+	}
+	if len(indices) == 1 {
+		return &ast.IndexExpr{X: x, Index: indices[0]}
+	}
+	return &ast.IndexListExpr{X: x, Indices: indices}
+}
+
+func (c *simplifyContext) unionExpr(union *types.Union, pos token.Pos) ast.Expr {
+	var x ast.Expr
+	for i := 0; i < union.Len(); i++ {
+		term := c.typeExpr(union.Term(i).Type(), pos)
+		if term == nil {
+			return nil
+		}
+		if union.Term(i).Tilde() {
+			term = &ast.UnaryExpr{Op: token.TILDE, X: term}
+		}
+		if x == nil {
+			x = term
+			continue
+		}
+		x = &ast.BinaryExpr{X: x, Op: token.OR, Y: term}
+	}
+	return x
+}
+
+func (c *simplifyContext) fieldList(tuple *types.Tuple, variadic bool, pos token.Pos) *ast.FieldList {
+	list := &ast.FieldList{}
+	for i := 0; i < tuple.Len(); i++ {
+		t := tuple.At(i).Type()
+		if variadic && i == tuple.Len()-1 {
+			elem := c.typeExpr(t.(*types.Slice).Elem(), pos)
+			if elem == nil {
+				return nil
+			}
+			list.List = append(list.List, &ast.Field{Type: &ast.Ellipsis{Elt: elem}})
+			continue
+		}
+		typ := c.typeExpr(t, pos)
+		if typ == nil {
+			return nil
+		}
+		list.List = append(list.List, &ast.Field{Type: typ})
+	}
+	return list
+}
+
+// typeNameIdent returns an identifier that refers to obj, or nil if obj is not
+// visible in the current scope.
+func (c *simplifyContext) typeNameIdent(obj *types.TypeName) ast.Expr {
+	if c.scope != nil {
+		if c.lookupParent(c.scope, obj.Name()) != obj {
+			return nil
+		}
+	}
+	id := ast.NewIdent(obj.Name())
+	c.recordUse(id, obj)
+	return id
+}
+
+// universeTypeIdent returns an identifier for a predeclared type, or nil if
+// it is shadowed in the current scope.
+func (c *simplifyContext) universeTypeIdent(name string) ast.Expr {
+	if !c.isUniverse(name) {
+		return nil
+	}
+	return c.universeIdent(name)
+}
+
+// isUniverse reports whether name refers to the predeclared object of that
+// name in the current scope.
+func (c *simplifyContext) isUniverse(name string) bool {
+	if c.scope == nil {
+		return true
+	}
+	return c.lookupParent(c.scope, name) == types.Universe.Lookup(name)
+}
+
+// canDenote reports whether typeExpr can denote t in the current scope.
+func (c *simplifyContext) canDenote(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Invalid, types.UntypedNil:
+			return false
+		case types.UnsafePointer:
+			return true
+		}
+		return c.isUniverse(types.Default(t).(*types.Basic).Name())
+	case *types.Alias:
+		return c.canDenoteNamed(t.Obj(), t.TypeArgs())
+	case *types.Named:
+		return c.canDenoteNamed(t.Obj(), t.TypeArgs())
+	case *types.TypeParam:
+		return c.scope == nil || c.lookupParent(c.scope, t.Obj().Name()) == t.Obj()
+	case *types.Pointer:
+		return c.canDenote(t.Elem())
+	case *types.Slice:
+		return c.canDenote(t.Elem())
+	case *types.Array:
+		return c.canDenote(t.Elem())
+	case *types.Map:
+		return c.canDenote(t.Key()) && c.canDenote(t.Elem())
+	case *types.Chan:
+		return c.canDenote(t.Elem())
+	case *types.Signature:
+		return c.canDenoteTuple(t.Params()) && c.canDenoteTuple(t.Results())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if f := t.Field(i); !f.Exported() && f.Pkg() != c.pkg || !c.canDenote(f.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Interface:
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			if !c.canDenote(t.EmbeddedType(i)) {
+				return false
+			}
+		}
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			if m := t.ExplicitMethod(i); !m.Exported() && m.Pkg() != c.pkg || !c.canDenote(m.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Union:
+		for i := 0; i < t.Len(); i++ {
+			if !c.canDenote(t.Term(i).Type()) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *simplifyContext) canDenoteNamed(obj *types.TypeName, typeArgs *types.TypeList) bool {
+	switch {
+	case obj.Pkg() == nil:
+		if !c.isUniverse(obj.Name()) {
+			return false
+		}
+	case obj.Pkg() == c.pkg:
+		if c.scope != nil && c.lookupParent(c.scope, obj.Name()) != obj {
+			return false
+		}
+	case !obj.Exported():
+		return false
+	}
+	for i := 0; i < typeArgs.Len(); i++ {
+		if !c.canDenote(typeArgs.At(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *simplifyContext) canDenoteTuple(tuple *types.Tuple) bool {
+	for i := 0; i < tuple.Len(); i++ {
+		if !c.canDenote(tuple.At(i).Type()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// lowerTypeSwitch turns a type switch statement into a chain of if statements
+// with comma-ok type assertions within a switch statement that only has a
+// default clause, like lowerSwitch does for expression switches. The operand
+// gets stored in a temporary variable, which the variable declared by the type
+// switch is bound to in each clause that uses it. A clause with a single type
+// asserts into another temporary first, so that the variable is not in scope
+// for the types of the later clauses:
+//
+//	switch v := x.(type) {
+//	case int:
+//		f(v)
+//	case string, nil:
+//		g(v)
+//	}
+//
+// becomes
+//
+//	switch {
+//	default:
+//		_1 := x
+//		if _2, _3 := _1.(int); _3 {
+//			v := _2
+//			f(v)
+//		} else {
+//			_, _4 := _1.(string)
+//			if !_4 {
+//				_4 = _1 == nil
+//			}
+//			if _4 {
+//				v := _1
+//				g(v)
+//			}
+//		}
+//	}
+func (c *simplifyContext) lowerTypeSwitch(stmts *[]ast.Stmt, s *ast.TypeSwitchStmt) {
+	wrapClause := &ast.CaseClause{}
+	newS := &ast.SwitchStmt{
+		Switch: s.Switch,
+		Body:   &ast.BlockStmt{List: []ast.Stmt{wrapClause}},
+	}
+	c.recordScope(newS, c.info.Scopes[s])
+	c.recordScope(wrapClause, c.info.Scopes[s])
+	*stmts = append(*stmts, newS)
+	stmts = &wrapClause.Body
+	defer c.enterScope(s)()
+
+	c.simplifyStmt(stmts, s.Init)
+
+	var x ast.Expr
+	switch a := s.Assign.(type) {
+	case *ast.ExprStmt:
+		x = a.X.(*ast.TypeAssertExpr).X
+	case *ast.AssignStmt:
+		x = a.Rhs[0].(*ast.TypeAssertExpr).X
+	default:
+		panic("unexpected type switch assign")
+	}
+
+	var nonDefaultClauses []*ast.CaseClause
+	var defaultClause *ast.CaseClause
+	for _, cc := range s.Body.List {
+		clause := cc.(*ast.CaseClause)
+		if clause.List == nil {
+			defaultClause = clause
+			continue
+		}
+		nonDefaultClauses = append(nonDefaultClauses, clause)
+	}
+	needsOperand := len(nonDefaultClauses) != 0 || (defaultClause != nil && c.typeSwitchVar(defaultClause) != nil)
+	operand, _ := c.makeTag(stmts, x, needsOperand).(*ast.Ident)
+	*stmts = append(*stmts, unwrapBlock(c.typeSwitchToIfElse(operand, nonDefaultClauses, defaultClause))...)
+}
+
+func (c *simplifyContext) typeSwitchToIfElse(operand *ast.Ident, nonDefaultClauses []*ast.CaseClause, defaultClause *ast.CaseClause) ast.Stmt {
+	if len(nonDefaultClauses) == 0 {
+		if defaultClause != nil {
+			s := c.toElseBranch(c.typeSwitchClauseBody(operand, defaultClause), c.info.Scopes[defaultClause])
+			c.setOrigin(defaultClause, s)
+			return s
+		}
+		return nil
+	}
+
+	clause := nonDefaultClauses[0]
+	leave := c.enterScope(clause)
+	var stmts []ast.Stmt
+	done := c.trackOrigin(&stmts, clause)
+	ifStmt := &ast.IfStmt{If: clause.Case}
+	value := operand
+	if len(clause.List) == 1 && !c.info.Types[clause.List[0]].IsNil() {
+		// the variable gets the asserted type
+		var v ast.Expr = c.blankIdent()
+		if obj := c.typeSwitchVar(clause); obj != nil {
+			value = c.newIdent(obj.Type(), clause.Case)
+			v = value
+		}
+		ok := c.newIdent(types.Typ[types.Bool], clause.Case)
+		ifStmt.Init = &ast.AssignStmt{
+			Lhs: []ast.Expr{v, ok},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{c.typeAssert(operand, clause.List[0])},
+		}
+		ifStmt.Cond = c.ref(ok)
+	} else if len(clause.List) == 1 {
+		// the variable keeps the type of the operand, which is nil
+		ifStmt.Cond = c.isNil(operand)
+	} else {
+		// the variable keeps the type of the operand
+		var ok *ast.Ident
+		for i, t := range clause.List {
+			var test ast.Stmt
+			switch {
+			case c.info.Types[t].IsNil():
+				if i == 0 {
+					ok = c.newIdent(types.Typ[types.Bool], clause.Case)
+					test = simpleAssign(ok, token.DEFINE, c.isNil(operand))
+				} else {
+					test = simpleAssign(c.ref(ok), token.ASSIGN, c.isNil(operand))
+				}
+			case i == 0:
+				ok = c.newIdent(types.Typ[types.Bool], clause.Case)
+				test = &ast.AssignStmt{
+					Lhs: []ast.Expr{c.newBlankIdent(c.info.TypeOf(t), clause.Case), ok},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{c.typeAssert(operand, t)},
+				}
+			default:
+				test = &ast.AssignStmt{
+					Lhs: []ast.Expr{c.blankIdent(), c.ref(ok)},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{c.typeAssert(operand, t)},
+				}
+			}
+			if i == 0 {
+				stmts = append(stmts, test)
+				continue
+			}
+			stmts = append(stmts, &ast.IfStmt{
+				Cond: c.negate(c.ref(ok)),
+				Body: &ast.BlockStmt{List: []ast.Stmt{test}},
+			})
+		}
+		ifStmt.Cond = c.ref(ok)
+	}
+	leave()
+	done()
+	ifStmt.Body = &ast.BlockStmt{List: c.typeSwitchClauseBody(value, clause)}
+	c.recordScope(ifStmt, c.info.Scopes[clause])
+	c.setOrigin(clause, ifStmt)
+	ifStmt.Else = c.typeSwitchToIfElse(operand, nonDefaultClauses[1:], defaultClause)
+	stmts = append(stmts, ifStmt)
+	branch := c.toElseBranch(stmts, c.info.Scopes[clause])
+	c.setOrigin(clause, branch)
+	return branch
+}
+
+// typeSwitchClauseBody simplifies the body of a clause of a type switch. It
+// starts with the declaration of the variable of the type switch with the
+// value x, unless the clause does not use it.
+func (c *simplifyContext) typeSwitchClauseBody(x *ast.Ident, clause *ast.CaseClause) []ast.Stmt {
+	body := c.simplifyClauseBody(clause, clause.Body)
+	if obj := c.typeSwitchVar(clause); obj != nil {
+		body = append([]ast.Stmt{simpleAssign(c.defIdent(obj), token.DEFINE, c.ref(x))}, body...)
+	}
+	return body
+}
+
+// typeSwitchVar returns the variable that the type switch declares in clause, or
+// nil if there is none or the clause does not use it.
+func (c *simplifyContext) typeSwitchVar(clause *ast.CaseClause) *types.Var {
+	obj, _ := c.info.Implicits[clause].(*types.Var)
+	if obj == nil {
+		return nil
+	}
+	used := false
+	for _, s := range clause.Body {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && c.info.Uses[id] == obj {
+				used = true
+			}
+			return !used
+		})
+	}
+	if !used {
+		return nil
+	}
+	return obj
+}
+
+// defIdent returns a new identifier that declares obj.
+func (c *simplifyContext) defIdent(obj types.Object) *ast.Ident {
+	id := ast.NewIdent(obj.Name())
+	c.recordDef(id, obj)
+	return id
+}
+
+// isNil returns the comparison of operand with nil.
+func (c *simplifyContext) isNil(operand *ast.Ident) ast.Expr {
+	return c.setType(&ast.BinaryExpr{
+		X:  c.ref(operand),
+		Op: token.EQL,
+		Y:  c.setType(c.universeIdent("nil"), types.Typ[types.UntypedNil]),
+	}, types.Typ[types.Bool])
+}
+
+// typeAssert returns the type assertion of operand to the type t.
+func (c *simplifyContext) typeAssert(operand *ast.Ident, t ast.Expr) ast.Expr {
+	return c.setType(&ast.TypeAssertExpr{
+		X:    c.ref(operand),
+		Type: t,
+	}, c.info.TypeOf(t))
+}
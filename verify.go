@@ -0,0 +1,94 @@
+package astrewrite
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// VerifyOptions configures the type-checking of simplified code.
+type VerifyOptions struct {
+	// Fset is the file set of the original files.
+	Fset *token.FileSet
+
+	// Importer is used to import the dependencies of the package. It defaults
+	// to importer.Default().
+	Importer types.Importer
+
+	// GoVersion is the language version used for type-checking, see
+	// types.Config.GoVersion.
+	GoVersion string
+
+	// Error is called for every error found in the simplified code.
+	Error func(err error)
+}
+
+// Verify prints files, which make up the package with the given import path,
+// parses the printed source again and type-checks it. It returns all syntax and
+// type errors. The printed source refers back to the positions of the nodes in
+// opts.Fset, so errors are reported at the location in the original source from
+// which the offending code was generated. The function opts.Error is not used.
+func Verify(path string, files []*ast.File, opts *VerifyOptions) []error {
+	var errs []error
+	fset := token.NewFileSet()
+	var printedFiles []*ast.File
+	for _, file := range files {
+		var buf bytes.Buffer
+		if err := Fprint(&buf, opts.Fset, file, true); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// The line directives written by the printer hold the original file names.
+		// Relative names get resolved against the directory of the parsed file, so
+		// it must not have one.
+		filename := filepath.Base(opts.Fset.Position(file.Package).Filename)
+		printedFile, err := parser.ParseFile(fset, filename, buf.Bytes(), parser.ParseComments)
+		if err != nil {
+			if list, ok := err.(scanner.ErrorList); ok {
+				for _, err := range list {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+		printedFiles = append(printedFiles, printedFile)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+
+	config := &types.Config{
+		Importer:  opts.Importer,
+		GoVersion: opts.GoVersion,
+		Error: func(err error) {
+			errs = append(errs, err)
+		},
+	}
+	if config.Importer == nil {
+		config.Importer = importer.Default()
+	}
+	config.Check(path, fset, printedFiles, nil)
+	return errs
+}
+
+func (c *simplifyContext) verify(files []*ast.File) {
+	if c.verifyOpts == nil {
+		return
+	}
+	path := "main"
+	if c.pkg != nil {
+		path = c.pkg.Path()
+	}
+	for _, err := range Verify(path, files, c.verifyOpts) {
+		if c.verifyOpts.Error != nil {
+			c.verifyOpts.Error(err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	forEachTestFile(t, func(name string, opts Options, fset *token.FileSet, inFile *ast.File, pkg *types.Package, typesInfo *types.Info) {
+		opts.Verify = &VerifyOptions{
+			Fset: fset,
+			Error: func(err error) {
+				t.Errorf("%s: %s", name, err)
+			},
+		}
+		SimplifyPackage([]*ast.File{inFile}, pkg, typesInfo, &opts)
+	})
+}
+
+func TestVerifyPositions(t *testing.T) {
+	src := `package main
+
+func main() {
+	x := f()
+	g(x)
+}
+
+func f() int { return 0 }
+func g(int) {}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// break the code on line 5 and move it in front of line 4
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	body.List[1].(*ast.ExprStmt).X.(*ast.CallExpr).Fun.(*ast.Ident).Name = "h"
+	body.List[0], body.List[1] = body.List[1], body.List[0]
+
+	errs := Verify("main", []*ast.File{file}, &VerifyOptions{Fset: fset})
+	// undefined: h, undefined: x, declared and not used: x
+	lines := []int{5, 5, 4}
+	if len(errs) != len(lines) {
+		t.Fatalf("expected %d errors, got %v", len(lines), errs)
+	}
+	for i, line := range lines {
+		pos := errs[i].(types.Error)
+		if got := pos.Fset.Position(pos.Pos); got.Filename != "test.go" || got.Line != line {
+			t.Errorf("error %q: expected position test.go:%d, got %s", errs[i], line, got)
+		}
+	}
+}
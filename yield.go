@@ -0,0 +1,518 @@
+package astrewrite
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// lowerToYield lowers the range loop s into a call of the iterator function
+// returned by seq. The loop body becomes the yield function passed to the
+// iterator. Its parameters have the given types and take the place of the
+// iteration variables. It reports false and emits nothing if s can not be
+// lowered.
+//
+// Branch and return statements in the body that leave the loop make the yield
+// function return false. A control variable then tells the code following the
+// call which of them to carry out. Deferred calls in the body are collected in a
+// list that gets run when the surrounding function returns. Unlike in the range
+// loop, a call of recover in such a deferred call does not stop a panic.
+func (c *simplifyContext) lowerToYield(stmts *[]ast.Stmt, s *ast.RangeStmt, paramTypes []types.Type, seq func(stmts *[]ast.Stmt) ast.Expr) bool {
+	for _, name := range []string{"bool", "len", "append"} {
+		if !c.isUniverse(name) {
+			return false
+		}
+	}
+	for _, t := range paramTypes {
+		if !c.canDenote(t) {
+			return false
+		}
+	}
+	if hasReturnValues(s.Body) && (c.funcSig == nil || !c.canDenoteTuple(c.funcSig.Results())) {
+		return false
+	}
+
+	y := &yieldBody{c: c, pos: s.For, labels: make(map[string]bool), exitCodes: make(map[string]int)}
+	if label, ok := c.loopLabels[s]; ok {
+		// the label is no longer needed on the loop, see simplifyStmt
+		y.label = label.Name
+		delete(c.loopLabels, s)
+	}
+	shared := c.sharesIterVars(s)
+	if shared {
+		// The shared iteration variables get declared in a block of their own, so
+		// they do not clash with other declarations.
+		block := &ast.BlockStmt{}
+		*stmts = append(*stmts, block)
+		stmts = &block.List
+	}
+	n := len(*stmts)
+	iterator := seq(stmts)
+	if shared {
+		id := c.newIdent(c.info.TypeOf(iterator), s.For)
+		*stmts = append(*stmts, simpleAssign(id, token.DEFINE, iterator))
+		iterator = c.ref(id)
+		for i, x := range []ast.Expr{s.Key, s.Value} {
+			if !isBlank(x) {
+				*stmts = append(*stmts, &ast.DeclStmt{
+					Decl: &ast.GenDecl{
+						Tok: token.VAR,
+						Specs: []ast.Spec{&ast.ValueSpec{
+							Names: []*ast.Ident{x.(*ast.Ident)},
+							Type:  c.typeExpr(paramTypes[i], x.Pos()),
+						}},
+					},
+				})
+			}
+		}
+	}
+
+	// The parameter types belong to the enclosing scope, where the iteration
+	// variables do not shadow them.
+	paramTypeExprs := make([]ast.Expr, len(paramTypes))
+	for i, t := range paramTypes {
+		paramTypeExprs[i] = c.typeExpr(t, s.For)
+	}
+
+	leave := c.enterScope(s)
+	params := &ast.FieldList{}
+	paramVars := make([]*types.Var, len(paramTypes))
+	iterVars := []ast.Expr{s.Key, s.Value}
+	var lhs, rhs []ast.Expr
+	for i, t := range paramTypes {
+		var v ast.Expr
+		if i < len(iterVars) {
+			v = iterVars[i]
+		}
+		var name *ast.Ident
+		switch {
+		case isBlank(v):
+			name = c.newBlankIdent(t, s.For)
+		case s.Tok == token.DEFINE && !shared:
+			name = v.(*ast.Ident)
+		default:
+			name = c.newIdent(t, v.Pos())
+			lhs = append(lhs, c.iterVar(v, shared))
+			rhs = append(rhs, c.ref(name))
+		}
+		paramVars[i], _ = c.info.Defs[name].(*types.Var)
+		if paramVars[i] == nil {
+			paramVars[i] = types.NewVar(s.For, c.pkg, name.Name, t)
+		}
+		params.List = append(params.List, &ast.Field{
+			Names: []*ast.Ident{name},
+			Type:  paramTypeExprs[i],
+		})
+	}
+	var prefix []ast.Stmt
+	if len(lhs) != 0 {
+		c.simplifyStmt(&prefix, &ast.AssignStmt{
+			Lhs:    lhs,
+			Tok:    token.ASSIGN,
+			TokPos: s.TokPos,
+			Rhs:    rhs,
+		})
+	}
+	leave()
+
+	body := c.simplifyBlock(s.Body)
+	collectLabels(body, y.labels)
+	body.List = y.rewriteList(body.List, false, false)
+	funcBody := c.iterationBody(s, shared, prefix, body)
+	if n := len(funcBody.List); n == 0 || !isReturn(funcBody.List[n-1]) {
+		funcBody.List = append(funcBody.List, y.yieldReturn(true, token.NoPos))
+	}
+
+	funcType := &ast.FuncType{
+		Func:    s.For,
+		Params:  params,
+		Results: &ast.FieldList{List: []*ast.Field{{Type: c.universeIdent("bool")}}},
+	}
+	c.recordScope(funcType, c.info.Scopes[s])
+	yield := c.setType(&ast.FuncLit{Type: funcType, Body: funcBody}, types.NewSignatureType(nil, nil, nil,
+		types.NewTuple(paramVars...),
+		types.NewTuple(types.NewVar(token.NoPos, nil, "", types.Typ[types.Bool])),
+		false,
+	))
+
+	*stmts = append(*stmts, y.decls...)
+	*stmts = append(*stmts, &ast.ExprStmt{
+		X: c.setType(&ast.CallExpr{Fun: iterator, Args: []ast.Expr{yield}}, types.NewTuple()),
+	})
+	for i, exit := range y.exits {
+		*stmts = append(*stmts, &ast.IfStmt{
+			Cond: c.setType(&ast.BinaryExpr{
+				X:  c.ref(y.control),
+				Op: token.EQL,
+				Y:  c.intConst(int64(i + 1)),
+			}, types.Typ[types.Bool]),
+			Body: &ast.BlockStmt{List: []ast.Stmt{exit}},
+		})
+	}
+	if !shared && len(y.decls) != 0 {
+		// The declarations go into a block, so a goto statement can jump over the
+		// lowered loop.
+		block := &ast.BlockStmt{List: append([]ast.Stmt(nil), (*stmts)[n:]...)}
+		*stmts = append((*stmts)[:n], block)
+	}
+	return true
+}
+
+// yieldBody rewrites the body of a loop that becomes a yield function.
+type yieldBody struct {
+	c      *simplifyContext
+	pos    token.Pos       // position of the loop
+	label  string          // label of the loop
+	labels map[string]bool // labels declared within the body
+
+	decls     []ast.Stmt     // declarations to put in front of the loop
+	control   *ast.Ident     // control variable, holds the number of the exit to take
+	exits     []ast.Stmt     // statements to run after the loop, one per exit
+	exitCodes map[string]int // exits of branch statements by their target
+	results   []*ast.Ident   // variables holding the values of a return statement
+	defers    *ast.Ident     // list of deferred calls
+}
+
+func (y *yieldBody) rewriteList(list []ast.Stmt, inLoop, inBreakable bool) []ast.Stmt {
+	var newList []ast.Stmt
+	for _, s := range list {
+		newList = append(newList, y.rewriteStmt(s, inLoop, inBreakable)...)
+	}
+	return newList
+}
+
+// rewriteStmt rewrites the statements in s that leave the loop body. The flags
+// tell whether s is nested in a loop or any statement that "break" refers to.
+// The nodes of the body are fresh copies, so they get updated in place.
+func (y *yieldBody) rewriteStmt(s ast.Stmt, inLoop, inBreakable bool) []ast.Stmt {
+	switch s := s.(type) {
+	case *ast.BranchStmt:
+		if s.Label != nil && y.labels[s.Label.Name] {
+			return []ast.Stmt{s}
+		}
+		switch s.Tok {
+		case token.BREAK:
+			if s.Label == nil && inBreakable {
+				return []ast.Stmt{s}
+			}
+			if s.Label == nil || s.Label.Name == y.label {
+				return []ast.Stmt{y.yieldReturn(false, s.Pos())}
+			}
+			return y.exit("break "+s.Label.Name, s)
+		case token.CONTINUE:
+			if s.Label == nil && inLoop {
+				return []ast.Stmt{s}
+			}
+			if s.Label == nil || s.Label.Name == y.label {
+				return []ast.Stmt{y.yieldReturn(true, s.Pos())}
+			}
+			return y.exit("continue "+s.Label.Name, s)
+		case token.GOTO:
+			return y.exit("goto "+s.Label.Name, s)
+		}
+
+	case *ast.ReturnStmt:
+		if len(s.Results) == 0 {
+			return y.exit("return", s)
+		}
+		if y.results == nil {
+			results := y.c.funcSig.Results()
+			values := make([]ast.Expr, results.Len())
+			for i := range values {
+				id := y.c.newIdent(results.At(i).Type(), s.Pos())
+				y.decls = append(y.decls, &ast.DeclStmt{
+					Decl: &ast.GenDecl{
+						Tok: token.VAR,
+						Specs: []ast.Spec{&ast.ValueSpec{
+							Names: []*ast.Ident{id},
+							Type:  y.c.typeExpr(results.At(i).Type(), s.Pos()),
+						}},
+					},
+				})
+				y.results = append(y.results, id)
+				values[i] = y.c.ref(id)
+			}
+			y.exitCodes["return values"] = y.addExit(&ast.ReturnStmt{Return: s.Return, Results: values})
+		}
+		lhs := make([]ast.Expr, len(y.results))
+		for i, id := range y.results {
+			lhs[i] = y.c.ref(id)
+		}
+		return append([]ast.Stmt{&ast.AssignStmt{
+			Lhs:    lhs,
+			Tok:    token.ASSIGN,
+			TokPos: s.Return,
+			Rhs:    s.Results,
+		}}, y.exitStmts(y.exitCodes["return values"], s.Pos())...)
+
+	case *ast.DeferStmt:
+		return y.deferStmt(s)
+
+	case *ast.BlockStmt:
+		s.List = y.rewriteList(s.List, inLoop, inBreakable)
+
+	case *ast.LabeledStmt:
+		stmts := y.rewriteStmt(s.Stmt, inLoop, inBreakable)
+		if len(stmts) == 1 {
+			s.Stmt = stmts[0]
+		} else {
+			s.Stmt = &ast.BlockStmt{List: stmts}
+		}
+
+	case *ast.IfStmt:
+		s.Body.List = y.rewriteList(s.Body.List, inLoop, inBreakable)
+		if s.Else != nil {
+			s.Else = y.rewriteStmt(s.Else, inLoop, inBreakable)[0]
+		}
+
+	case *ast.ForStmt:
+		s.Body.List = y.rewriteList(s.Body.List, true, true)
+
+	case *ast.RangeStmt:
+		s.Body.List = y.rewriteList(s.Body.List, true, true)
+
+	case *ast.SwitchStmt:
+		for _, cc := range s.Body.List {
+			cc.(*ast.CaseClause).Body = y.rewriteList(cc.(*ast.CaseClause).Body, inLoop, true)
+		}
+
+	case *ast.TypeSwitchStmt:
+		for _, cc := range s.Body.List {
+			cc.(*ast.CaseClause).Body = y.rewriteList(cc.(*ast.CaseClause).Body, inLoop, true)
+		}
+
+	case *ast.SelectStmt:
+		for _, cc := range s.Body.List {
+			cc.(*ast.CommClause).Body = y.rewriteList(cc.(*ast.CommClause).Body, inLoop, true)
+		}
+	}
+	return []ast.Stmt{s}
+}
+
+// exit replaces a statement that leaves the loop body. The statement is carried
+// out after the loop instead. Statements with the same target share an exit.
+func (y *yieldBody) exit(target string, s ast.Stmt) []ast.Stmt {
+	code, ok := y.exitCodes[target]
+	if !ok {
+		code = y.addExit(s)
+		y.exitCodes[target] = code
+	}
+	return y.exitStmts(code, s.Pos())
+}
+
+func (y *yieldBody) addExit(s ast.Stmt) int {
+	if y.control == nil {
+		y.control = y.c.newIdent(types.Typ[types.Int], y.pos)
+		y.decls = append(y.decls, simpleAssign(y.control, token.DEFINE, y.c.intConst(0)))
+	}
+	y.exits = append(y.exits, s)
+	return len(y.exits)
+}
+
+func (y *yieldBody) exitStmts(code int, pos token.Pos) []ast.Stmt {
+	return []ast.Stmt{
+		simpleAssign(y.c.ref(y.control), token.ASSIGN, y.c.intConst(int64(code))),
+		y.yieldReturn(false, pos),
+	}
+}
+
+func (y *yieldBody) yieldReturn(value bool, pos token.Pos) ast.Stmt {
+	return &ast.ReturnStmt{Return: pos, Results: []ast.Expr{y.c.boolConst(value)}}
+}
+
+// deferStmt replaces a defer statement with adding the call to the list of
+// deferred calls. The function value and the arguments are evaluated right away.
+func (y *yieldBody) deferStmt(s *ast.DeferStmt) []ast.Stmt {
+	c := y.c
+	var stmts []ast.Stmt
+	capture := func(x ast.Expr) ast.Expr {
+		id := c.newIdent(types.Default(c.info.TypeOf(x)), s.Defer)
+		stmts = append(stmts, simpleAssign(id, token.DEFINE, x))
+		return c.ref(id)
+	}
+	call := s.Call
+	fun := call.Fun
+	_, isBuiltin := c.info.Uses[identOf(fun)].(*types.Builtin)
+	if !isBuiltin && !isStaticFunc(c.info, fun) {
+		fun = capture(fun)
+	}
+
+	var args []ast.Expr
+	if tuple, ok := c.info.TypeOf(singleArg(call.Args)).(*types.Tuple); ok {
+		lhs := make([]ast.Expr, tuple.Len())
+		for i := range lhs {
+			id := c.newIdent(tuple.At(i).Type(), s.Defer)
+			lhs[i] = id
+			args = append(args, c.ref(id))
+		}
+		stmts = append(stmts, &ast.AssignStmt{Lhs: lhs, Tok: token.DEFINE, Rhs: call.Args})
+	} else {
+		for _, arg := range call.Args {
+			if tv := c.info.Types[arg]; tv.Value != nil || tv.IsNil() || tv.IsType() {
+				args = append(args, arg)
+				continue
+			}
+			args = append(args, capture(arg))
+		}
+	}
+
+	deferred := fun
+	if sig, ok := c.info.TypeOf(fun).(*types.Signature); isBuiltin || !ok || len(args) != 0 || sig.Params().Len() != 0 || sig.Results().Len() != 0 {
+		deferred = c.setType(&ast.FuncLit{
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: c.setType(&ast.CallExpr{
+				Fun:      fun,
+				Args:     args,
+				Ellipsis: call.Ellipsis,
+			}, c.info.TypeOf(call))}}},
+		}, deferredFuncType)
+	}
+
+	if y.defers == nil {
+		y.defers = c.newIdent(types.NewSlice(deferredFuncType), y.pos)
+		y.decls = append(y.decls, c.deferredCallsDecl(y.defers, y.pos)...)
+	}
+	return append(stmts, simpleAssign(c.ref(y.defers), token.ASSIGN, c.builtinCall("append", types.NewSlice(deferredFuncType), c.ref(y.defers), deferred)))
+}
+
+var deferredFuncType = types.NewSignatureType(nil, nil, nil, nil, nil, false)
+
+// deferredCallsDecl declares the list of deferred calls and defers running them
+// in reverse order, each one deferred on its own so that a panic does not skip
+// the others.
+func (c *simplifyContext) deferredCallsDecl(list *ast.Ident, pos token.Pos) []ast.Stmt {
+	funcType := func() ast.Expr {
+		return &ast.FuncType{Params: &ast.FieldList{}}
+	}
+	i := c.newIdent(types.Typ[types.Int], pos)
+	return []ast.Stmt{
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{&ast.ValueSpec{
+					Names: []*ast.Ident{list},
+					Type:  &ast.ArrayType{Elt: funcType()},
+				}},
+			},
+		},
+		&ast.DeferStmt{
+			Call: c.setType(&ast.CallExpr{
+				Fun: c.setType(&ast.FuncLit{
+					Type: funcType().(*ast.FuncType),
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ForStmt{
+						Init: simpleAssign(i, token.DEFINE, c.intConst(0)),
+						Cond: c.setType(&ast.BinaryExpr{
+							X:  c.ref(i),
+							Op: token.LSS,
+							Y:  c.builtinCall("len", types.Typ[types.Int], c.ref(list)),
+						}, types.Typ[types.Bool]),
+						Post: &ast.IncDecStmt{X: c.ref(i), Tok: token.INC},
+						Body: &ast.BlockStmt{List: []ast.Stmt{&ast.DeferStmt{
+							Call: c.setType(&ast.CallExpr{
+								Fun: c.setType(&ast.IndexExpr{X: c.ref(list), Index: c.ref(i)}, deferredFuncType),
+							}, types.NewTuple()).(*ast.CallExpr),
+						}}},
+					}}},
+				}, deferredFuncType),
+			}, types.NewTuple()).(*ast.CallExpr),
+		},
+	}
+}
+
+// collectLabels adds the labels declared in body, outside of function literals, to labels.
+func collectLabels(body ast.Node, labels map[string]bool) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.LabeledStmt:
+			labels[n.Label.Name] = true
+		}
+		return true
+	})
+}
+
+// hasReturnValues reports whether body contains a return statement with
+// results, outside of function literals.
+func hasReturnValues(body ast.Node) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if len(n.Results) != 0 {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// usesLabel reports whether body contains a goto statement with the given
+// label, outside of function literals.
+func usesLabel(body ast.Node, label string) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BranchStmt:
+			if n.Tok == token.GOTO && n.Label.Name == label {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// isStaticFunc reports whether x denotes a declared function, possibly
+// instantiated, so that evaluating it has no effect.
+func isStaticFunc(info *types.Info, x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return isStaticFunc(info, x.X)
+	case *ast.IndexExpr:
+		return isStaticFunc(info, x.X)
+	case *ast.IndexListExpr:
+		return isStaticFunc(info, x.X)
+	}
+	fn, ok := info.Uses[identOf(x)].(*types.Func)
+	if !ok {
+		return false
+	}
+	if sel, ok := x.(*ast.SelectorExpr); ok {
+		_, isPkg := info.Uses[identOf(sel.X)].(*types.PkgName)
+		return isPkg
+	}
+	return fn.Type().(*types.Signature).Recv() == nil
+}
+
+// identOf returns the identifier x or the selected identifier if x is a
+// selector expression, ignoring parentheses.
+func identOf(x ast.Expr) *ast.Ident {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x
+	case *ast.SelectorExpr:
+		return x.Sel
+	case *ast.ParenExpr:
+		return identOf(x.X)
+	}
+	return nil
+}
+
+// isReturn reports whether s is a return statement.
+func isReturn(s ast.Stmt) bool {
+	_, ok := s.(*ast.ReturnStmt)
+	return ok
+}
+
+func singleArg(args []ast.Expr) ast.Expr {
+	if len(args) != 1 {
+		return nil
+	}
+	return args[0]
+}